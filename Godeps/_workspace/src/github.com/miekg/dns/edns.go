@@ -288,7 +288,7 @@ func (e *EDNS0_SUBNET) unpack(b []byte) error {
 	switch e.Family {
 	case 1:
 		addr := make([]byte, 4)
-		for i := 0; i < int(e.SourceNetmask/8); i++ {
+		for i := 0; i < (int(e.SourceNetmask)+7)/8; i++ {
 			if i >= len(addr) || 4+i >= len(b) {
 				return ErrBuf
 			}
@@ -297,7 +297,7 @@ func (e *EDNS0_SUBNET) unpack(b []byte) error {
 		e.Address = net.IPv4(addr[0], addr[1], addr[2], addr[3])
 	case 2:
 		addr := make([]byte, 16)
-		for i := 0; i < int(e.SourceNetmask/8); i++ {
+		for i := 0; i < (int(e.SourceNetmask)+7)/8; i++ {
 			if i >= len(addr) || 4+i >= len(b) {
 				return ErrBuf
 			}
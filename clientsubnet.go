@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// clientSubnet returns the EDNS client subnet option (RFC 7871) of a query,
+// with the address masked to the source prefix length, or nil if the query
+// doesn't have one. An error is returned for an option that's invalid.
+func clientSubnet(req *dns.Msg) (*dns.EDNS0_SUBNET, error) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return nil, nil
+	}
+
+	for _, option := range opt.Option {
+		subnet, ok := option.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+
+		var address net.IP
+		switch subnet.Family {
+		case 1:
+			if subnet.SourceNetmask > net.IPv4len*8 || subnet.Address.To4() == nil {
+				return nil, fmt.Errorf("Invalid IPv4 client subnet %s", subnet)
+			}
+			address = subnet.Address.To4().Mask(net.CIDRMask(int(subnet.SourceNetmask), net.IPv4len*8))
+		case 2:
+			if subnet.SourceNetmask > net.IPv6len*8 || len(subnet.Address) != net.IPv6len {
+				return nil, fmt.Errorf("Invalid IPv6 client subnet %s", subnet)
+			}
+			address = subnet.Address.Mask(net.CIDRMask(int(subnet.SourceNetmask), net.IPv6len*8))
+		default:
+			return nil, fmt.Errorf("Unknown client subnet address family %d", subnet.Family)
+		}
+
+		if subnet.SourceScope != 0 {
+			return nil, fmt.Errorf("Client subnet with a scope prefix length in a query %s", subnet)
+		}
+
+		return &dns.EDNS0_SUBNET{
+			Code:          subnet.Code,
+			Family:        subnet.Family,
+			SourceNetmask: subnet.SourceNetmask,
+			Address:       address,
+			DraftOption:   subnet.DraftOption}, nil
+	}
+
+	return nil, nil
+}
+
+// setClientSubnetScope adds the client subnet option of a query to the
+// response to it, with the scope prefix length set to how much of the client
+// address the answers depend on. That's all of the address that was given if
+// views, GeoIP or client hashing could have picked the answers, and none of it
+// otherwise.
+func (h *Handler) setClientSubnetScope(req *dns.Msg, msg *dns.Msg, subnet *dns.EDNS0_SUBNET) {
+	response := *subnet
+	if h.dependsOnClient(msg) {
+		response.SourceScope = subnet.SourceNetmask
+	}
+
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(req.IsEdns0().UDPSize(), false)
+		opt = msg.IsEdns0()
+	}
+
+	opt.Option = append(opt.Option, &response)
+}
+
+// dependsOnClient returns true if the answers in the given response could be
+// different for another client.
+func (h *Handler) dependsOnClient(msg *dns.Msg) bool {
+	if len(h.views) > 0 || h.resolver.geo != nil {
+		return true
+	}
+
+	checked := make(map[uint16]bool)
+	for _, rr := range msg.Answer {
+		header := rr.Header()
+		if checked[header.Rrtype] {
+			continue
+		}
+		checked[header.Rrtype] = true
+
+		if h.resolver.RecordSettings(header.Name, typeToString(header.Rrtype)).Order == OrderHash {
+			return true
+		}
+	}
+
+	return false
+}
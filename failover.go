@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rcrowley/go-metrics"
+)
+
+// failoverTier returns the failover tier of a record, given its key relative
+// to the key of the record type (e.g /.failover/2/0 is in tier 2). Records
+// outside of a .failover directory are in the primary tier, zero.
+func failoverTier(key string) (tier int, ok bool) {
+	if !strings.HasPrefix(key, "/.failover/") {
+		return 0, true
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(key, "/.failover/"), "/", 2)
+	if len(segments) != 2 {
+		return 0, false
+	}
+
+	tier, err := strconv.Atoi(segments[0])
+	if err != nil || tier < 1 {
+		return 0, false
+	}
+
+	return tier, true
+}
+
+// activeAnswers returns the healthy answers (and their weights) from the
+// first failover tier that has any, so backup records are only returned once
+// every record in the tiers before them is unhealthy or absent. If none of
+// the records are healthy the whole of the first tier is returned, as an
+// answer that might work is better than none.
+func (r *Resolver) activeAnswers(records []*EtcdRecord, answers []dns.RR, weights []uint32) ([]dns.RR, []uint32) {
+	excluded_counter := metrics.GetOrRegisterCounter("resolver.health.excluded", metrics.DefaultRegistry)
+	fail_open_counter := metrics.GetOrRegisterCounter("resolver.health.fail_open", metrics.DefaultRegistry)
+	failover_counter := metrics.GetOrRegisterCounter("resolver.failover.count", metrics.DefaultRegistry)
+
+	var tiers []int
+	seen := make(map[int]bool)
+	for _, record := range records {
+		if !seen[record.tier] {
+			tiers = append(tiers, record.tier)
+			seen[record.tier] = true
+		}
+	}
+	sort.Ints(tiers)
+
+	if len(tiers) == 1 && r.health == nil {
+		return answers, weights
+	}
+
+	inTier := func(tier int, healthyOnly bool) (active []dns.RR, activeWeights []uint32) {
+		for i, record := range records {
+			if record.tier == tier && (!healthyOnly || r.recordHealthy(record)) {
+				active = append(active, answers[i])
+				activeWeights = append(activeWeights, weights[i])
+			}
+		}
+		return
+	}
+
+	for _, tier := range tiers {
+		active, activeWeights := inTier(tier, true)
+		if len(active) == 0 {
+			continue
+		}
+
+		if tier != tiers[0] {
+			debugMsg("Failing over to tier ", tier, " for "+answers[0].Header().Name)
+			failover_counter.Inc(1)
+		}
+
+		all, _ := inTier(tier, false)
+		excluded_counter.Inc(int64(len(all) - len(active)))
+
+		return active, activeWeights
+	}
+
+	debugMsg("Every record for " + answers[0].Header().Name + " is unhealthy, returning them all")
+	fail_open_counter.Inc(1)
+
+	return inTier(tiers[0], false)
+}
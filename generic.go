@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/miekg/dns"
+)
+
+// typeToString returns the name of the given record type as used in etcd
+// keys, falling back to the RFC 3597 TYPEnnn form for types without a name.
+func typeToString(rrType uint16) string {
+	if typeStr, ok := dns.TypeToString[rrType]; ok {
+		return typeStr
+	}
+
+	return fmt.Sprintf("TYPE%d", rrType)
+}
+
+// stringToType is the reverse of typeToString.
+func stringToType(typeStr string) (rrType uint16, ok bool) {
+	typeStr = strings.ToUpper(typeStr)
+	if rrType, ok = dns.StringToType[typeStr]; ok {
+		return
+	}
+
+	if strings.HasPrefix(typeStr, "TYPE") {
+		value, err := strconv.ParseUint(typeStr[4:], 10, 16)
+		if err == nil {
+			return uint16(value), true
+		}
+	}
+
+	return 0, false
+}
+
+// storableType returns true if records of the given type can be stored and
+// served. Meta and query types (RFC 6895) such as OPT, AXFR or ANY can't.
+func storableType(rrType uint16) bool {
+	return rrType != dns.TypeNone && rrType != dns.TypeOPT && (rrType < 128 || rrType > 255)
+}
+
+// convertGeneric is used for record types without a converter of their own.
+// The value is the record data in standard zone file presentation format (as
+// it would appear after the type in a zone file), or the RFC 3597 "\#" format
+// for opaque data. Domain names in the record data must be fully qualified.
+func convertGeneric(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+	value := strings.TrimSpace(node.Value)
+
+	if len(value) == 0 {
+		err = &NodeConversionError{
+			Node:          node,
+			Message:       "Value is empty",
+			AttemptedType: header.Rrtype}
+		return
+	}
+
+	if strings.HasPrefix(value, `\#`) {
+		rdata, e := parseUnknownRdata(value)
+		if e != nil {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       e.Error(),
+				AttemptedType: header.Rrtype}
+			return
+		}
+
+		rr = rawRR(header, rdata)
+		return
+	}
+
+	text := fmt.Sprintf("%s %d IN %s %s", header.Name, header.Ttl, typeToString(header.Rrtype), value)
+	rr, e := dns.NewRR(text)
+	if e != nil || rr == nil {
+		err = &NodeConversionError{
+			Node:          node,
+			Message:       fmt.Sprintf("Value %s isn't valid %s record data: %v", value, typeToString(header.Rrtype), e),
+			AttemptedType: header.Rrtype}
+		return nil, err
+	}
+
+	return
+}
+
+// convertPresentation is used for records stored under .RR keys, where the
+// value is the record type followed by the record data in presentation format,
+// for example "MX 10 mail.discodns.net." or "TYPE65534 \# 2 abcd".
+func convertPresentation(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+	value := strings.TrimSpace(node.Value)
+	fields := []string{value, ""}
+	if i := strings.IndexAny(value, " \t"); i >= 0 {
+		fields = []string{value[:i], value[i+1:]}
+	}
+
+	rrType, ok := stringToType(fields[0])
+	if !ok || !storableType(rrType) {
+		err = &NodeConversionError{
+			Node:          node,
+			Message:       fmt.Sprintf("Type '%s' isn't a known record type", fields[0]),
+			AttemptedType: header.Rrtype}
+		return
+	}
+
+	header.Rrtype = rrType
+	return convertGeneric(&etcd.Node{Key: node.Key, Value: fields[1]}, header)
+}
+
+// parseUnknownRdata parses record data in the RFC 3597 format, "\#" followed
+// by the length of the data and the data itself as (optionally space
+// separated) hex.
+func parseUnknownRdata(value string) (rdata []byte, err error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || fields[0] != `\#` {
+		return nil, fmt.Errorf("Value %s isn't valid RFC 3597 record data", value)
+	}
+
+	length, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Length '%s' isn't a 16bit unsigned int", fields[1])
+	}
+
+	rdata, err = hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("Record data '%s' isn't a valid hex string", strings.Join(fields[2:], " "))
+	}
+
+	if len(rdata) != int(length) {
+		return nil, fmt.Errorf("Record data is %d bytes long, expected %d", len(rdata), length)
+	}
+
+	return
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rcrowley/go-metrics"
+)
+
+// GeoIP locates clients using a local MaxMind DB file (such as GeoLite2
+// Country or City), reloading it whenever the file changes.
+type GeoIP struct {
+	path string
+
+	mutex   sync.RWMutex
+	db      *mmdbReader
+	modTime time.Time
+
+	// Metrics
+	lookupCounter      metrics.Counter
+	missCounter        metrics.Counter
+	reloadCounter      metrics.Counter
+	reloadErrorCounter metrics.Counter
+}
+
+// NewGeoIP loads the MaxMind DB file at the given path.
+func NewGeoIP(path string) (*GeoIP, error) {
+	geo := &GeoIP{
+		path:               path,
+		lookupCounter:      metrics.GetOrRegisterCounter("geoip.lookups", metrics.DefaultRegistry),
+		missCounter:        metrics.GetOrRegisterCounter("geoip.misses", metrics.DefaultRegistry),
+		reloadCounter:      metrics.GetOrRegisterCounter("geoip.reloads", metrics.DefaultRegistry),
+		reloadErrorCounter: metrics.GetOrRegisterCounter("geoip.reload_errors", metrics.DefaultRegistry)}
+
+	if err := geo.Reload(); err != nil {
+		return nil, err
+	}
+
+	return geo, nil
+}
+
+// Reload loads the database file again if it's changed since it was last
+// loaded. The database that's already loaded is kept if the file is invalid.
+func (g *GeoIP) Reload() error {
+	info, err := os.Stat(g.path)
+	if err != nil {
+		g.reloadErrorCounter.Inc(1)
+		return err
+	}
+
+	g.mutex.RLock()
+	unchanged := g.db != nil && info.ModTime().Equal(g.modTime)
+	g.mutex.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+
+	db, err := openMMDB(g.path)
+	if err != nil {
+		g.reloadErrorCounter.Inc(1)
+		return err
+	}
+
+	debugMsg("Loaded GeoIP database " + g.path)
+	g.reloadCounter.Inc(1)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.db, g.modTime = db, info.ModTime()
+	return nil
+}
+
+// Run checks the database file for changes once per interval, forever.
+func (g *GeoIP) Run(interval time.Duration) {
+	for _ = range time.Tick(interval) {
+		if err := g.Reload(); err != nil {
+			logger.Printf("[WARNING] Failed to reload GeoIP database %s: %s", g.path, err)
+		}
+	}
+}
+
+// Locations returns the locations of the given client, most specific first,
+// as the lower case ISO codes of its country and subdivision (e.g us-ca), its
+// country (us) and its continent (na). Nil is returned if the client can't be
+// located.
+func (g *GeoIP) Locations(client net.IP) (locations []string) {
+	if client == nil {
+		return
+	}
+
+	g.lookupCounter.Inc(1)
+
+	g.mutex.RLock()
+	db := g.db
+	g.mutex.RUnlock()
+
+	value, err := db.Lookup(client)
+	if err != nil {
+		debugMsg("Error looking up client "+client.String()+" in GeoIP database: ", err)
+	}
+
+	record, _ := value.(map[string]interface{})
+	country := geoCode(record["country"], "iso_code")
+	continent := geoCode(record["continent"], "code")
+
+	if len(country) > 0 {
+		if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+			if subdivision := geoCode(subdivisions[0], "iso_code"); len(subdivision) > 0 {
+				locations = append(locations, country+"-"+subdivision)
+			}
+		}
+		locations = append(locations, country)
+	}
+	if len(continent) > 0 {
+		locations = append(locations, continent)
+	}
+
+	if len(locations) == 0 {
+		g.missCounter.Inc(1)
+	}
+
+	return
+}
+
+// geoCode returns the given code of a place in a GeoIP record, in lower case
+func geoCode(place interface{}, field string) string {
+	values, _ := place.(map[string]interface{})
+	code, _ := values[field].(string)
+	return strings.ToLower(code)
+}
+
+// LookupGeoAnswers returns the records of the given type stored for the
+// closest location of the given client beneath the given name, such as
+// /net/discodns/www/.geo/us/.A for clients in the US. Nil is returned when
+// there are none for any of its locations, so the records for everyone else
+// are used.
+func (r *Resolver) LookupGeoAnswers(name string, rrType uint16, client net.IP) (answers []dns.RR, err error) {
+	converter, ok := converters[rrType]
+	if !ok {
+		converter = convertGeneric
+	}
+
+	for _, location := range r.geo.Locations(client) {
+		answers, settings, err := r.lookupRecords(name, "/.geo/"+location+"/."+typeToString(rrType), rrType, converter)
+		if err != nil {
+			return nil, err
+		} else if len(answers) > 0 {
+			debugMsg("Answering from GeoIP location " + location)
+			return r.OrderAnswers(name, rrType, answers, *settings, client), nil
+		}
+	}
+
+	return
+}
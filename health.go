@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/rcrowley/go-metrics"
+)
+
+// HealthChecker probes the health checks declared on records in the
+// background, so answers can exclude unhealthy records without waiting on a
+// probe. Checks are URLs of the following forms;
+//   - tcp://host:port, healthy if a connection can be made
+//   - http://host[:port]/path (or https), healthy for a 2xx or 3xx response
+//   - etcd:///path/to/key, healthy while the key exists
+type HealthChecker struct {
+	etcd       *etcd.Client
+	etcdPrefix string
+	interval   time.Duration
+	timeout    time.Duration
+
+	mutex  sync.RWMutex
+	checks map[string]*healthCheck
+}
+
+type healthCheck struct {
+	healthy   bool
+	requested time.Time // When a record with this check was last looked up
+}
+
+// forgetAfter is how many intervals a check is kept for after it was last
+// needed, so checks for records that have gone away aren't probed forever
+const forgetAfter = 10
+
+var metricNameInvalid = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+func NewHealthChecker(client *etcd.Client, etcdPrefix string, interval time.Duration, timeout time.Duration) *HealthChecker {
+	return &HealthChecker{
+		etcd:       client,
+		etcdPrefix: etcdPrefix,
+		interval:   interval,
+		timeout:    timeout,
+		checks:     make(map[string]*healthCheck)}
+}
+
+// Healthy returns false if the given check has been probed and failed. Checks
+// that haven't been probed yet are assumed to be healthy, and will be probed
+// from now on.
+func (c *HealthChecker) Healthy(check string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, ok := c.checks[check]
+	if !ok {
+		debugMsg("Adding health check " + check)
+		state = &healthCheck{healthy: true}
+		c.checks[check] = state
+	}
+
+	state.requested = time.Now()
+	return state.healthy
+}
+
+// Run probes every check once per interval, forever.
+func (c *HealthChecker) Run() {
+	for _ = range time.Tick(c.interval) {
+		c.CheckAll()
+	}
+}
+
+// CheckAll probes every check concurrently, waiting for them all to finish.
+func (c *HealthChecker) CheckAll() {
+	c.mutex.Lock()
+	checks := make([]string, 0, len(c.checks))
+	for check, state := range c.checks {
+		if time.Since(state.requested) > forgetAfter*c.interval {
+			debugMsg("Forgetting health check " + check)
+			delete(c.checks, check)
+		} else {
+			checks = append(checks, check)
+		}
+	}
+	c.mutex.Unlock()
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(checks))
+	for _, check := range checks {
+		go func(check string) {
+			defer wg.Done()
+
+			err := c.probe(check)
+			c.record(check, err)
+		}(check)
+	}
+
+	wg.Wait()
+}
+
+// record stores the result of probing the given check, and updates the
+// metrics for it.
+func (c *HealthChecker) record(check string, err error) {
+	name := "health." + metricNameInvalid.ReplaceAllString(check, "_")
+	gauge := metrics.GetOrRegisterGauge(name+".healthy", metrics.DefaultRegistry)
+
+	if err != nil {
+		debugMsg("Health check "+check+" failed: ", err)
+		metrics.GetOrRegisterCounter(name+".failures", metrics.DefaultRegistry).Inc(1)
+		gauge.Update(0)
+	} else {
+		metrics.GetOrRegisterCounter(name+".successes", metrics.DefaultRegistry).Inc(1)
+		gauge.Update(1)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if state, ok := c.checks[check]; ok {
+		state.healthy = err == nil
+	}
+}
+
+// probe runs the given check once, returning an error if it's unhealthy.
+func (c *HealthChecker) probe(check string) error {
+	target, err := url.Parse(check)
+	if err != nil {
+		return err
+	}
+
+	switch target.Scheme {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", target.Host, c.timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http", "https":
+		client := http.Client{Timeout: c.timeout}
+		response, err := client.Get(check)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		if response.StatusCode < 200 || response.StatusCode >= 400 {
+			return fmt.Errorf("Unexpected status %s", response.Status)
+		}
+		return nil
+	case "etcd":
+		_, err := c.etcd.Get(c.etcdPrefix+target.Path, false, false)
+		return err
+	}
+
+	return fmt.Errorf("Unsupported health check '%s'", check)
+}
+
+// recordHealthy returns false if the given record has a health check that's
+// failing. Every record is healthy when health checks are disabled.
+func (r *Resolver) recordHealthy(record *EtcdRecord) bool {
+	if r.health == nil {
+		return true
+	}
+
+	check := recordCheck(record)
+	return len(check) == 0 || r.health.Healthy(check)
+}
+
+// recordCheck returns the health check for the given record, given by a .check
+// key next to the record or a "check" field in a JSON value.
+func recordCheck(record *EtcdRecord) string {
+	if len(record.check) > 0 {
+		return record.check
+	}
+
+	check, _ := record.metadata["check"].(string)
+	return check
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// jsonFields maps the name of each record type (as used in etcd keys) to the
+// fields of a JSON value that make up the tab-delimited plain value for that
+// type. Types not listed here use a single "rdata" field, holding the record
+// data in presentation format.
+var jsonFields = map[string][]string{
+	"A":     {"host"},
+	"AAAA":  {"host"},
+	"TXT":   {"text"},
+	"CNAME": {"host"},
+	"DNAME": {"host"},
+	"ALIAS": {"host"},
+	"NS":    {"host"},
+	"PTR":   {"host"},
+	"SRV":   {"priority", "weight", "port", "host"},
+	"MX":    {"preference", "host"},
+	"SOA":   {"ns", "mbox", "refresh", "retry", "expire", "minttl"},
+	"CAA":   {"flags", "tag", "value"},
+	"SSHFP": {"algorithm", "type", "fingerprint"},
+	"TLSA":  {"usage", "selector", "matching_type", "certificate"},
+	"NAPTR": {"order", "preference", "flags", "service", "regexp", "replacement"},
+	"URI":   {"priority", "weight", "target"},
+	"SVCB":  {"priority", "target", "params"},
+	"HTTPS": {"priority", "target", "params"},
+	"RR":    {"type", "rdata"},
+}
+
+// jsonOptionalFields are the fields that can be left out of a JSON value,
+// every other field of the type is required
+var jsonOptionalFields = map[string]bool{
+	"params": true, // SVCB and HTTPS records in alias mode have none
+}
+
+// parseJSONValue decodes the value of the given node if it's a JSON object,
+// for a record of the given type (named as in etcd keys), returning nil
+// otherwise so the value is treated as a plain value. TXT records can hold
+// any text, including JSON, so they're only treated as JSON values when
+// they have a "text" field.
+func parseJSONValue(node *etcd.Node, typeStr string) (metadata map[string]interface{}) {
+	value := strings.TrimSpace(node.Value)
+	if !strings.HasPrefix(value, "{") {
+		return nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&metadata); err != nil {
+		debugMsg("Unable to decode JSON value of "+node.Key+": ", err)
+		return nil
+	}
+
+	if _, ok := metadata["text"]; typeStr == "TXT" && !ok {
+		return nil
+	}
+
+	return
+}
+
+// jsonTtl returns the ttl given in JSON metadata, if there is a valid one.
+func jsonTtl(metadata map[string]interface{}) (ttl uint32, ok bool) {
+	value, ok := metadata["ttl"].(json.Number)
+	if !ok {
+		return 0, false
+	}
+
+	ttlValue, err := strconv.ParseUint(value.String(), 10, 32)
+	if err != nil {
+		debugMsg("Unable to convert ttl value to int: ", value)
+		return 0, false
+	}
+
+	return uint32(ttlValue), true
+}
+
+// recordNode returns the node of the given record, for records of the given
+// type (named as in etcd keys). Records stored as JSON are returned as a copy
+// of their node holding the equivalent plain value, so they can be passed to
+// any of the converters.
+func recordNode(record *EtcdRecord, typeStr string, rrType uint16) (node *etcd.Node, err error) {
+	if record.metadata == nil {
+		return record.node, nil
+	}
+
+	fields, ok := jsonFields[typeStr]
+	if !ok {
+		fields = []string{"rdata"}
+	}
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		switch value := record.metadata[field].(type) {
+		case nil:
+			if jsonOptionalFields[field] {
+				continue
+			}
+			return nil, &NodeConversionError{
+				Node:          record.node,
+				Message:       fmt.Sprintf("Missing field '%s'", field),
+				AttemptedType: rrType}
+		case string:
+			parts[i] = value
+		case json.Number:
+			parts[i] = value.String()
+		case []interface{}:
+			// Lists of strings, such as the strings of a TXT record
+			strs := make([]string, len(value))
+			for j, v := range value {
+				if strs[j], ok = v.(string); !ok {
+					return nil, &NodeConversionError{
+						Node:          record.node,
+						Message:       fmt.Sprintf("Field '%s' must be a list of strings", field),
+						AttemptedType: rrType}
+				}
+			}
+			parts[i] = strings.Join(strs, "\t")
+		default:
+			return nil, &NodeConversionError{
+				Node:          record.node,
+				Message:       fmt.Sprintf("Field '%s' must be a string or number", field),
+				AttemptedType: rrType}
+		}
+	}
+
+	copied := *record.node
+	copied.Value = strings.Join(parts, "\t")
+
+	return &copied, nil
+}
+
+// recordWeight returns the weight of the given record, for records of the
+// given type (named as in etcd keys), if it has one. Weights can be given by a
+// .weight key next to the record, or a "weight" field in JSON values for types
+// where that isn't part of the record itself (such as SRV).
+func recordWeight(record *EtcdRecord, typeStr string) (weight uint32, ok bool) {
+	if record.weighted {
+		return record.weight, true
+	}
+
+	for _, field := range jsonFields[typeStr] {
+		if field == "weight" {
+			return 0, false
+		}
+	}
+
+	value, ok := record.metadata["weight"].(json.Number)
+	if !ok {
+		return 0, false
+	}
+
+	weightValue, err := strconv.ParseUint(value.String(), 10, 32)
+	if err != nil {
+		debugMsg("Unable to convert weight value to int: ", value)
+		return 0, false
+	}
+
+	return uint32(weightValue), true
+}
@@ -29,8 +29,21 @@ var (
 		GraphiteServer   string   `long:"graphite" description:"Graphite server to send metrics to"`
 		GraphiteDuration int      `long:"graphite-duration" description:"Duration to periodically send metrics to the graphite server" default:"10"`
 		DefaultTtl       uint32   `short:"t" long:"default-ttl" description:"Default TTL to return on records without an explicit TTL" default:"300"`
+		MinTtl           uint32   `long:"min-ttl" description:"Minimum TTL to return on any record" default:"0"`
+		MaxTtl           uint32   `long:"max-ttl" description:"Maximum TTL to return on any record (0 for no maximum)" default:"0"`
 		Accept           []string `long:"accept" description:"Limit DNS queries to a set of domain:[type,...] pairs"`
 		Reject           []string `long:"reject" description:"Limit DNS queries to a set of domain:[type,...] pairs"`
+		AliasUpstream    string   `long:"alias-upstream" description:"host:port of a nameserver used to resolve ALIAS targets outside of our zones"`
+		AnyPolicy        string   `long:"any-policy" description:"How to answer ANY queries over UDP (full, tcp, single or hinfo)" default:"full"`
+		GenericRR        bool     `long:"generic-rr" description:"Also look for records in zone file format under .RR keys"`
+		AnswerOrder      string   `long:"answer-order" description:"Order to return records of the same type in (stable, rotate, shuffle or hash)" default:"stable"`
+		SettingsCache    int      `long:"settings-cache" description:"Seconds to cache settings such as .ttl keys for, 0 to read them for every query" default:"5"`
+		HealthInterval   int      `long:"health-interval" description:"Seconds between health checks of records, 0 to disable them" default:"10"`
+		HealthTimeout    int      `long:"health-timeout" description:"Seconds to wait for each health check" default:"2"`
+		Views            []string `long:"view" description:"Answer clients in a set of networks from records beneath another etcd prefix, as prefix=cidr[,cidr...]"`
+		GeoIPDatabase    string   `long:"geoip-db" description:"MaxMind DB file (e.g GeoLite2-Country.mmdb) to locate clients with, for answers by location"`
+		GeoIPReload      int      `long:"geoip-reload" description:"Seconds between checks for changes to the GeoIP database file" default:"60"`
+		SkyDNSPrefix     string   `long:"skydns-prefix" description:"Also read services in the SkyDNS layout from beneath this etcd key (e.g /skydns)"`
 	}
 )
 
@@ -46,6 +59,39 @@ func main() {
 		debugMsg("Debug mode enabled")
 	}
 
+	switch Options.AnyPolicy {
+	case AnyPolicyFull, AnyPolicyTCP, AnyPolicySingle, AnyPolicyHINFO:
+	default:
+		logger.Fatalf("Unknown ANY policy '%s'", Options.AnyPolicy)
+	}
+
+	if !validOrder(Options.AnswerOrder) {
+		logger.Fatalf("Unknown answer order '%s'", Options.AnswerOrder)
+	}
+
+	views := make([]*View, 0)
+	for _, option := range Options.Views {
+		view, err := ParseView(option)
+		if err != nil {
+			logger.Fatalf("Invalid view '%s': %s", option, err)
+		}
+
+		debugMsg("Adding view for " + view.prefix)
+		views = append(views, view)
+	}
+
+	var geo *GeoIP
+	if len(Options.GeoIPDatabase) > 0 {
+		geo, err = NewGeoIP(Options.GeoIPDatabase)
+		if err != nil {
+			logger.Fatalf("Failed to load GeoIP database: %s", err)
+		}
+
+		if Options.GeoIPReload > 0 {
+			go geo.Run(time.Duration(Options.GeoIPReload) * time.Second)
+		}
+	}
+
 	// Create an ETCD client
 	etcd := etcd.NewClient(Options.EtcdHosts)
 	if !etcd.SyncCluster() {
@@ -82,12 +128,24 @@ func main() {
 
 	// Start up the DNS resolver server
 	server := &Server{
-		addr:       Options.ListenAddress,
-		port:       Options.ListenPort,
-		etcd:       etcd,
-		rTimeout:   time.Duration(5) * time.Second,
-		wTimeout:   time.Duration(5) * time.Second,
-		defaultTtl: Options.DefaultTtl,
+		addr:           Options.ListenAddress,
+		port:           Options.ListenPort,
+		etcd:           etcd,
+		rTimeout:       time.Duration(5) * time.Second,
+		wTimeout:       time.Duration(5) * time.Second,
+		defaultTtl:     Options.DefaultTtl,
+		minTtl:         Options.MinTtl,
+		maxTtl:         Options.MaxTtl,
+		aliasUpstream:  Options.AliasUpstream,
+		genericRR:      Options.GenericRR,
+		skydnsPrefix:   strings.TrimSuffix(Options.SkyDNSPrefix, "/"),
+		answerOrder:    Options.AnswerOrder,
+		healthInterval: time.Duration(Options.HealthInterval) * time.Second,
+		healthTimeout:  time.Duration(Options.HealthTimeout) * time.Second,
+		anyPolicy:      Options.AnyPolicy,
+		views:          views,
+		geo:            geo,
+		settingsTtl:    time.Duration(Options.SettingsCache) * time.Second,
 		queryFilterer: &QueryFilterer{acceptFilters: parseFilters(Options.Accept),
 			rejectFilters: parseFilters(Options.Reject)}}
 
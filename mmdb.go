@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB file
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbReader is a minimal reader for MaxMind DB files (such as the GeoIP2 and
+// GeoLite2 databases), as described at https://maxmind.github.io/MaxMind-DB/.
+// The whole file is held in memory.
+type mmdbReader struct {
+	buffer     []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint // The node IPv4 addresses start from in an IPv6 tree
+}
+
+// openMMDB reads and checks the MaxMind DB file at the given path.
+func openMMDB(path string) (*mmdbReader, error) {
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	marker := bytes.LastIndex(buffer, mmdbMetadataMarker)
+	if marker < 0 {
+		return nil, errors.New("Not a MaxMind DB file, no metadata found")
+	}
+
+	metadataStart := uint(marker + len(mmdbMetadataMarker))
+	value, _, err := decodeMMDB(buffer[metadataStart:], 0)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MaxMind DB metadata: %s", err)
+	}
+
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Invalid MaxMind DB metadata, expected a map")
+	}
+
+	reader := &mmdbReader{buffer: buffer}
+	for field, target := range map[string]*uint{
+		"node_count":  &reader.nodeCount,
+		"record_size": &reader.recordSize,
+		"ip_version":  &reader.ipVersion} {
+		value, ok := metadata[field].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("Invalid MaxMind DB metadata, missing %s", field)
+		}
+		*target = uint(value)
+	}
+
+	if reader.recordSize != 24 && reader.recordSize != 28 && reader.recordSize != 32 {
+		return nil, fmt.Errorf("Unsupported MaxMind DB record size %d", reader.recordSize)
+	}
+	if reader.ipVersion != 4 && reader.ipVersion != 6 {
+		return nil, fmt.Errorf("Unsupported MaxMind DB IP version %d", reader.ipVersion)
+	}
+
+	// The search tree is followed by 16 bytes of zeros, then the data
+	treeSize := reader.nodeCount * reader.recordSize / 4
+	reader.dataStart = treeSize + 16
+	if reader.dataStart > uint(marker) {
+		return nil, errors.New("Invalid MaxMind DB, search tree is larger than the file")
+	}
+
+	if reader.ipVersion == 6 {
+		for i := 0; i < 96 && reader.ipv4Start < reader.nodeCount; i++ {
+			reader.ipv4Start = reader.readRecord(reader.ipv4Start, 0)
+		}
+	}
+
+	return reader, nil
+}
+
+// Lookup returns the data for the network containing the given address, or
+// nil if the database has none.
+func (m *mmdbReader) Lookup(ip net.IP) (interface{}, error) {
+	address := ip.To4()
+	node := uint(0)
+
+	if address == nil {
+		if m.ipVersion == 4 {
+			return nil, nil
+		}
+		address = ip.To16()
+	} else if m.ipVersion == 6 {
+		node = m.ipv4Start
+	}
+
+	for i := 0; i < len(address)*8 && node < m.nodeCount; i++ {
+		bit := uint(address[i/8]>>(7-uint(i%8))) & 1
+		node = m.readRecord(node, bit)
+	}
+
+	if node == m.nodeCount {
+		return nil, nil
+	} else if node < m.nodeCount {
+		return nil, errors.New("Invalid MaxMind DB, search tree is too deep")
+	}
+
+	offset := node - m.nodeCount - 16
+	if m.dataStart+offset >= uint(len(m.buffer)) {
+		return nil, errors.New("Invalid MaxMind DB, data pointer is outside of the file")
+	}
+
+	value, _, err := decodeMMDB(m.buffer[m.dataStart:], offset)
+	return value, err
+}
+
+// readRecord returns the left (0) or right (1) record of the given node.
+func (m *mmdbReader) readRecord(node uint, bit uint) uint {
+	switch m.recordSize {
+	case 24:
+		b := m.buffer[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := m.buffer[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(m.buffer[node*8+bit*4:]))
+	}
+}
+
+// Types of the fields in the MaxMind DB data section
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbDouble  = 3
+	mmdbBytes   = 4
+	mmdbUint16  = 5
+	mmdbUint32  = 6
+	mmdbMap     = 7
+	mmdbInt32   = 8
+	mmdbUint64  = 9
+	mmdbUint128 = 10
+	mmdbArray   = 11
+	mmdbBoolean = 14
+	mmdbFloat   = 15
+)
+
+// mmdbMaxDepth is how deeply maps, arrays and pointers can be nested in the
+// data section, so a corrupt file that refers back to itself can't recurse
+// forever
+const mmdbMaxDepth = 64
+
+var errMMDBTruncated = errors.New("Invalid MaxMind DB, data is truncated")
+
+// decodeMMDB decodes the field at the given offset of the given data section,
+// returning its value and the offset of the next field. Maps are returned as
+// map[string]interface{}, arrays as []interface{} and unsigned integers as
+// uint64 (or []byte for 128 bit integers).
+func decodeMMDB(data []byte, offset uint) (value interface{}, next uint, err error) {
+	return decodeMMDBField(data, offset, 0)
+}
+
+// decodeMMDBField decodes a field nested the given depth beneath the one
+// decodeMMDB was asked for.
+func decodeMMDBField(data []byte, offset uint, depth int) (value interface{}, next uint, err error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, errMMDBTruncated
+	}
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("Invalid MaxMind DB, data is nested too deeply")
+	}
+
+	control := data[offset]
+	offset++
+
+	fieldType := uint(control >> 5)
+	if fieldType == mmdbPointer {
+		pointerSize := uint(control>>3) & 0x3
+		if offset+pointerSize+1 > uint(len(data)) {
+			return nil, 0, errMMDBTruncated
+		}
+
+		var pointer uint
+		b := data[offset : offset+pointerSize+1]
+		switch pointerSize {
+		case 0:
+			pointer = uint(control&0x7)<<8 | uint(b[0])
+		case 1:
+			pointer = (uint(control&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			pointer = (uint(control&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		case 3:
+			pointer = uint(binary.BigEndian.Uint32(b))
+		}
+
+		// Pointers can't point at other pointers
+		if pointer < uint(len(data)) && data[pointer]>>5 == mmdbPointer {
+			return nil, 0, errors.New("Invalid MaxMind DB, pointer to a pointer")
+		}
+
+		value, _, err = decodeMMDBField(data, pointer, depth+1)
+		return value, offset + pointerSize + 1, err
+	}
+
+	if fieldType == 0 {
+		if offset >= uint(len(data)) {
+			return nil, 0, errMMDBTruncated
+		}
+		fieldType = 7 + uint(data[offset])
+		offset++
+	}
+
+	size := uint(control & 0x1F)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(data)) {
+			return nil, 0, errMMDBTruncated
+		}
+
+		b := data[offset : offset+extra]
+		switch extra {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		case 3:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+		offset += extra
+	}
+
+	// Every key and value of a map, and every item of an array, takes at
+	// least a byte, so there can't be more of them than there are bytes left
+	remaining := uint(len(data)) - offset
+	if (fieldType == mmdbMap && size > remaining/2) || (fieldType == mmdbArray && size > remaining) {
+		return nil, 0, errMMDBTruncated
+	}
+
+	switch fieldType {
+	case mmdbMap:
+		values := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, item interface{}
+			if key, offset, err = decodeMMDBField(data, offset, depth+1); err != nil {
+				return
+			}
+			if item, offset, err = decodeMMDBField(data, offset, depth+1); err != nil {
+				return
+			}
+
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("Invalid MaxMind DB, map key isn't a string")
+			}
+			values[name] = item
+		}
+		return values, offset, nil
+	case mmdbArray:
+		values := make([]interface{}, size)
+		for i := range values {
+			if values[i], offset, err = decodeMMDBField(data, offset, depth+1); err != nil {
+				return
+			}
+		}
+		return values, offset, nil
+	case mmdbBoolean:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errMMDBTruncated
+	}
+	b := data[offset : offset+size]
+	next = offset + size
+
+	switch fieldType {
+	case mmdbString:
+		return string(b), next, nil
+	case mmdbBytes, mmdbUint128:
+		return append([]byte(nil), b...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("Invalid MaxMind DB, double isn't 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("Invalid MaxMind DB, float isn't 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		if size > 8 {
+			return nil, 0, errors.New("Invalid MaxMind DB, integer is too large")
+		}
+
+		var number uint64
+		for _, octet := range b {
+			number = number<<8 | uint64(octet)
+		}
+
+		if fieldType == mmdbInt32 {
+			return int64(int32(uint32(number))), next, nil
+		}
+		return number, next, nil
+	}
+
+	return nil, 0, fmt.Errorf("Unsupported MaxMind DB data type %d", fieldType)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Orders that records of the same type can be returned in
+const (
+	OrderStable  = "stable"  // The order they're stored in etcd
+	OrderRotate  = "rotate"  // Rotated by one place for every response
+	OrderShuffle = "shuffle" // Shuffled randomly for every response
+	OrderHash    = "hash"    // Ordered by hashing the client, for sticky answers
+)
+
+// rotations holds a counter for each set of records that's rotated, keyed by
+// their name and type, so each set rotates on its own
+var rotations = struct {
+	sync.Mutex
+	counters map[string]*uint64
+}{counters: make(map[string]*uint64)}
+
+// defaultRandom is used to select and shuffle answers, unless the resolver has
+// a random source of its own
+var defaultRandom = rand.New(&lockedSource{source: rand.NewSource(time.Now().UnixNano())})
+
+// lockedSource is a rand.Source that's safe for concurrent use
+type lockedSource struct {
+	mutex  sync.Mutex
+	source rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.source.Seed(seed)
+}
+
+// randomSource returns the source of randomness for selecting and shuffling
+// answers.
+func (r *Resolver) randomSource() *rand.Rand {
+	if r.random != nil {
+		return r.random
+	}
+	return defaultRandom
+}
+
+// validOrder returns true if the given order is one we know about
+func validOrder(order string) bool {
+	return order == OrderStable || order == OrderRotate || order == OrderShuffle || order == OrderHash
+}
+
+// OrderAnswers orders the given records of a single type found at the given
+// name for the given client (which may be nil), according to the given
+// settings, then limits them to the maximum number of answers.
+func (r *Resolver) OrderAnswers(name string, rrType uint16, answers []dns.RR, settings RecordSettings, client net.IP) []dns.RR {
+	if len(answers) > 1 {
+		switch settings.Order {
+		case OrderRotate:
+			key := strings.ToLower(name) + "/" + typeToString(rrType)
+			rotations.Lock()
+			counter, ok := rotations.counters[key]
+			if !ok {
+				counter = new(uint64)
+				rotations.counters[key] = counter
+			}
+			rotations.Unlock()
+
+			offset := int(atomic.AddUint64(counter, 1) % uint64(len(answers)))
+
+			rotated := make([]dns.RR, 0, len(answers))
+			rotated = append(rotated, answers[offset:]...)
+			answers = append(rotated, answers[:offset]...)
+		case OrderShuffle:
+			shuffled := make([]dns.RR, len(answers))
+			for i, j := range r.randomSource().Perm(len(answers)) {
+				shuffled[i] = answers[j]
+			}
+			answers = shuffled
+		case OrderHash:
+			if client != nil {
+				sortByClientHash(answers, client)
+			}
+		}
+	}
+
+	if max := int(settings.MaxAnswers); max > 0 && len(answers) > max {
+		answers = answers[:max]
+	}
+
+	return answers
+}
+
+// sortByClientHash sorts the given records by their rendezvous hash with the
+// given client, so each client consistently gets the same records first. When
+// a record is added or removed, only the clients it's (or was) the first record
+// for are affected.
+func sortByClientHash(records []dns.RR, client net.IP) {
+	scores := make(map[dns.RR]uint64)
+	for _, rr := range records {
+		// Only the record data is hashed, the TTL may change over time
+		hash := fnv.New64a()
+		hash.Write(client.To16())
+		hash.Write([]byte(strings.TrimPrefix(rr.String(), rr.Header().String())))
+		scores[rr] = hash.Sum64()
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return scores[records[i]] > scores[records[j]]
+	})
+}
+
+// SelectAnswers picks up to the given maximum number of the given answers (or
+// all of them, for zero) at random in proportion to their weights, returning
+// them in the order they were picked. Answers with a weight of zero are never
+// picked, unless every answer has a weight of zero.
+func (r *Resolver) SelectAnswers(answers []dns.RR, weights []uint32, max int) (selected []dns.RR) {
+	var candidates []int
+	var total uint64
+
+	for i := range answers {
+		if weights[i] > 0 {
+			candidates = append(candidates, i)
+			total += uint64(weights[i])
+		}
+	}
+
+	if len(candidates) == 0 {
+		// Answering with nothing would be worse, so treat them all equally
+		weights = make([]uint32, len(answers))
+		for i := range answers {
+			candidates = append(candidates, i)
+			weights[i] = 1
+		}
+		total = uint64(len(answers))
+	}
+
+	if max <= 0 || max > len(candidates) {
+		max = len(candidates)
+	}
+
+	random := r.randomSource()
+	for len(selected) < max {
+		pick := uint64(random.Int63n(int64(total)))
+		for j, i := range candidates {
+			if pick < uint64(weights[i]) {
+				selected = append(selected, answers[i])
+				total -= uint64(weights[i])
+				candidates = append(candidates[:j], candidates[j+1:]...)
+				break
+			}
+			pick -= uint64(weights[i])
+		}
+	}
+
+	return
+}
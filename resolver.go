@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
+	"math/rand"
 	"net"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,20 +19,39 @@ import (
 )
 
 type Resolver struct {
-	etcd       *etcd.Client
-	etcdPrefix string
-	defaultTtl uint32
+	etcd           *etcd.Client
+	etcdPrefix     string
+	defaultTtl     uint32
+	minTtl         uint32
+	maxTtl         uint32
+	aliasUpstream  string
+	genericRecords bool
+	skydnsPrefix   string
+	answerOrder    string
+	random         *rand.Rand     // For selecting and shuffling answers
+	health         *HealthChecker // Nil if health checks are disabled
+	geo            *GeoIP         // Nil without a GeoIP database
+	settingsCache  *settingsCache // Nil if settings aren't cached
 }
 
 type EtcdRecord struct {
-	node *etcd.Node
-	ttl  uint32
+	node       *etcd.Node
+	ttl        uint32
+	defaultTtl bool                   // The record has no TTL of its own
+	metadata   map[string]interface{} // Only set for JSON values
+	weight     uint32
+	weighted   bool   // The record has a weight, for selecting answers
+	check      string // The health check for the record, if it has one
+	tier       int    // The failover tier of the record, zero for primary
 }
 
 // GetFromStorage looks up a key in etcd and returns a slice of nodes. It supports two storage structures;
 //  - File:         /foo/bar/.A -> "value"
 //  - Directory:    /foo/bar/.A/0 -> "value-0"
 //                  /foo/bar/.A/1 -> "value-1"
+// Records in a directory can also be split into failover tiers;
+//  - Failover:     /foo/bar/.A/0 -> "primary"
+//                  /foo/bar/.A/.failover/1/0 -> "backup"
 func (r *Resolver) GetFromStorage(key string) (nodes []*EtcdRecord, err error) {
 
 	counter := metrics.GetOrRegisterCounter("resolver.etcd.query_count", metrics.DefaultRegistry)
@@ -43,35 +66,60 @@ func (r *Resolver) GetFromStorage(key string) (nodes []*EtcdRecord, err error) {
 		return
 	}
 
-	var findKeys func(node *etcd.Node, ttl uint32, tryTtl bool)
+	// The type is named by the last part of the key (e.g /net/disco/.A)
+	typeStr := key[strings.LastIndex(key, "/.")+2:]
+
+	var findKeys func(node *etcd.Node, ttl uint32, hasTtl bool, tryTtl bool)
 
 	nodes = make([]*EtcdRecord, 0)
-	findKeys = func(node *etcd.Node, ttl uint32, tryTtl bool) {
+	findKeys = func(node *etcd.Node, ttl uint32, hasTtl bool, tryTtl bool) {
 		if node.Dir == true {
+			first := len(nodes)
+			weights := make(map[string]uint32)
+			checks := make(map[string]string)
+
 			var lastValNode *etcd.Node
 			for _, node := range node.Nodes {
 
-				if strings.HasSuffix(node.Key, ".ttl") {
+				if strings.HasSuffix(node.Key, ".weight") {
+					weightValue, err := strconv.ParseUint(node.Value, 10, 32)
+					if err != nil {
+						debugMsg("Unable to convert weight value to int: ", node.Value)
+					} else {
+						weights[strings.TrimSuffix(node.Key, ".weight")] = uint32(weightValue)
+					}
+				} else if strings.HasSuffix(node.Key, ".check") {
+					checks[strings.TrimSuffix(node.Key, ".check")] = node.Value
+				} else if strings.HasSuffix(node.Key, ".ttl") {
 					ttlValue, err := strconv.ParseUint(node.Value, 10, 32)
 					if err != nil {
 						debugMsg("Unable to convert ttl value to int: ", node.Value)
 					} else if lastValNode == nil {
 						debugMsg(".ttl node with no matching value node: ", node.Key)
 					} else {
-						findKeys(lastValNode, uint32(ttlValue), false)
+						findKeys(lastValNode, uint32(ttlValue), true, false)
 						lastValNode = nil
 						continue
 					}
 				} else {
 					if lastValNode != nil {
-						findKeys(lastValNode, r.defaultTtl, false)
+						findKeys(lastValNode, r.defaultTtl, false, false)
 					}
 					lastValNode = node
 				}
 			}
 
 			if lastValNode != nil {
-				findKeys(lastValNode, r.defaultTtl, false)
+				findKeys(lastValNode, r.defaultTtl, false, false)
+			}
+
+			for _, record := range nodes[first:] {
+				if weight, ok := weights[record.node.Key]; ok {
+					record.weight, record.weighted = weight, true
+				}
+				if check, ok := checks[record.node.Key]; ok {
+					record.check = check
+				}
 			}
 		} else {
 			// If for some reason this is passed a ttl node unexpectedly, bail
@@ -80,6 +128,14 @@ func (r *Resolver) GetFromStorage(key string) (nodes []*EtcdRecord, err error) {
 				return
 			}
 
+			// JSON values can carry their own TTL, which takes precedence
+			metadata := parseJSONValue(node, typeStr)
+			if jsonTtlValue, ok := jsonTtl(metadata); ok {
+				ttl = jsonTtlValue
+				hasTtl = true
+				tryTtl = false
+			}
+
 			// If we don't have a TLL try and find one
 			if tryTtl {
 				ttlKey := node.Key + ".ttl"
@@ -92,15 +148,35 @@ func (r *Resolver) GetFromStorage(key string) (nodes []*EtcdRecord, err error) {
 						debugMsg("Unable to convert ttl value to int: ", response.Node.Value)
 					} else {
 						ttl = uint32(ttlValue)
+						hasTtl = true
 					}
 				}
 			}
 
-			nodes = append(nodes, &EtcdRecord{node, ttl})
+			nodes = append(nodes, &EtcdRecord{
+				node:       node,
+				ttl:        ttl,
+				defaultTtl: !hasTtl,
+				metadata:   metadata})
 		}
 	}
 
-	findKeys(response.Node, r.defaultTtl, true)
+	findKeys(response.Node, r.defaultTtl, false, true)
+
+	// Records beneath a .failover directory belong to the tier it's named
+	// after, everything else is in the primary tier
+	records := nodes[:0]
+	for _, record := range nodes {
+		tier, ok := failoverTier(strings.TrimPrefix(record.node.Key, response.Node.Key))
+		if !ok {
+			debugMsg("Ignoring record in invalid failover tier: ", record.node.Key)
+			continue
+		}
+
+		record.tier = tier
+		records = append(records, record)
+	}
+	nodes = records
 
 	return
 }
@@ -133,10 +209,24 @@ func (r *Resolver) Authority(domain string) (soa *dns.SOA) {
 	return
 }
 
+// maxChainDepth limits how many DNAME substitutions will be followed when
+// answering a single query, to protect against loops.
+const maxChainDepth = 8
+
 // Lookup responds to DNS messages of type Query, with a dns message containing Answers.
 // In the event that the query's value+type yields no known records, this falls back to
 // querying the given nameservers instead.
 func (r *Resolver) Lookup(req *dns.Msg) (msg *dns.Msg) {
+	return r.lookup(req, nil, 0)
+}
+
+// LookupForClient is the same as Lookup, for a query on behalf of the given
+// client, which is used to choose answers for that client.
+func (r *Resolver) LookupForClient(req *dns.Msg, client net.IP) (msg *dns.Msg) {
+	return r.lookup(req, client, 0)
+}
+
+func (r *Resolver) lookup(req *dns.Msg, client net.IP, depth int) (msg *dns.Msg) {
 	q := req.Question[0]
 
 	msg = new(dns.Msg)
@@ -152,29 +242,72 @@ func (r *Resolver) Lookup(req *dns.Msg) (msg *dns.Msg) {
 	var eChan chan error
 
 	if q.Qclass == dns.ClassINET {
-		aChan, eChan = r.AnswerQuestion(q)
-		answers, errors = gatherFromChannels(aChan, eChan)
+		// Records for the client's location take precedence
+		if r.geo != nil && q.Qtype != dns.TypeANY && storableType(q.Qtype) {
+			geoAnswers, err := r.LookupGeoAnswers(q.Name, q.Qtype, client)
+			if err != nil {
+				errors = append(errors, err)
+			}
+			answers = geoAnswers
+		}
+
+		if len(answers) == 0 && len(errors) == 0 {
+			aChan, eChan = r.answerQuestion(q, client)
+			answers, errors = gatherFromChannels(aChan, eChan)
+		}
 	}
 
 	errored = errored || len(errors) > 0
-	if len(answers) == 0 {
-		// If we failed to find any answers, let's keep looking up the tree for
-		// any wildcard domain entries.
-		parts := strings.Split(q.Name, ".")
-		for level := 1; level < len(parts); level++ {
-			domain := strings.Join(parts[level:], ".")
-			if len(domain) > 1 {
-				question := dns.Question{
-					Name:   "*." + dns.Fqdn(domain),
-					Qtype:  q.Qtype,
-					Qclass: q.Qclass}
-
-				aChan, eChan = r.AnswerQuestion(question)
-				answers, errors = gatherFromChannels(aChan, eChan)
-
-				errored = errored || len(errors) > 0
-				if len(answers) > 0 {
-					break
+	nodata := false
+	var dname *dns.DNAME
+	if len(answers) == 0 && !errored {
+		// Wildcards are handled as described in RFC 4592, a wildcard can only
+		// be used to synthesize answers when the name itself doesn't exist.
+		// If the name exists (or is an empty non-terminal) we answer with no
+		// data, otherwise we try the wildcard at the closest encloser.
+		exists, err := r.NameExists(q.Name)
+		if err != nil {
+			errored = true
+		} else if exists {
+			nodata = true
+		} else {
+			encloser, err := r.ClosestEncloser(q.Name)
+			if err != nil {
+				errored = true
+			} else if encloser != "" {
+				// Names beneath a DNAME can't exist, so the owner of any DNAME
+				// record that applies to this name is the closest encloser.
+				// DNAME substitution takes precedence over wildcards.
+				dnames, err := r.LookupAnswersForType(encloser, dns.TypeDNAME)
+				if err != nil {
+					errored = true
+				} else if len(dnames) > 1 {
+					debugMsg("Caught error", &RecordValueError{
+						Message:       "Multiple DNAME records is invalid",
+						AttemptedType: dns.TypeDNAME})
+					errored = true
+				} else if len(dnames) == 1 {
+					dname = dnames[0].(*dns.DNAME)
+					dname.Hdr.Name = encloser
+				} else {
+					wildcard := "*." + encloser
+					exists, err = r.NameExists(wildcard)
+					if err != nil {
+						errored = true
+					} else if exists {
+						debugMsg("Synthesizing answers from wildcard " + wildcard)
+
+						question := dns.Question{
+							Name:   wildcard,
+							Qtype:  q.Qtype,
+							Qclass: q.Qclass}
+
+						aChan, eChan = r.answerQuestion(question, client)
+						answers, errors = gatherFromChannels(aChan, eChan)
+
+						errored = errored || len(errors) > 0
+						nodata = len(answers) == 0
+					}
 				}
 			}
 		}
@@ -188,14 +321,23 @@ func (r *Resolver) Lookup(req *dns.Msg) (msg *dns.Msg) {
 		// TODO(tarnfeld): Send special TXT records with a server error response code
 		error_counter.Inc(1)
 		msg.SetRcode(req, dns.RcodeServerFailure)
+	} else if dname != nil {
+		hit_counter.Inc(1)
+		r.substituteDNAME(req, msg, dname, client, depth)
 	} else if len(answers) == 0 {
 		soa := r.Authority(q.Name)
 		miss_counter.Inc(1)
-		msg.SetRcode(req, dns.RcodeNameError)
+		if nodata {
+			msg.SetRcode(req, dns.RcodeSuccess)
+		} else {
+			msg.SetRcode(req, dns.RcodeNameError)
+		}
 		if soa != nil {
 			msg.Ns = []dns.RR{soa}
 		} else {
-			msg.Authoritative = false // No SOA? We're not authoritative
+			// No SOA? We're not authoritative, so refuse to answer
+			msg.Authoritative = false
+			msg.SetRcode(req, dns.RcodeRefused)
 		}
 	} else {
 		hit_counter.Inc(1)
@@ -203,6 +345,211 @@ func (r *Resolver) Lookup(req *dns.Msg) (msg *dns.Msg) {
 			rr.Header().Name = q.Name
 			msg.Answer = append(msg.Answer, rr)
 		}
+
+		msg.Extra = r.AdditionalRecords(msg.Answer)
+	}
+
+	return
+}
+
+// AdditionalRecords returns any records we hold for the targets of the given
+// answers, for the additional section of a response. Only NAPTR and URI
+// answers have their targets looked up. Errors are ignored, as the additional
+// section is only a courtesy to the client.
+func (r *Resolver) AdditionalRecords(answers []dns.RR) (extra []dns.RR) {
+	seen := make(map[string]bool)
+	for _, rr := range answers {
+		var target string
+		rrTypes := []uint16{dns.TypeA, dns.TypeAAAA}
+
+		switch rr := rr.(type) {
+		case *dns.NAPTR:
+			// The flags describe what the replacement should be looked
+			// up as (RFC 3403 section 4.1)
+			flags := strings.ToUpper(rr.Flags)
+			if flags == "S" {
+				rrTypes = []uint16{dns.TypeSRV}
+			} else if flags != "A" {
+				continue
+			}
+			target = rr.Replacement
+		case *dns.RFC3597:
+			if rr.Hdr.Rrtype != dns.TypeURI {
+				continue
+			}
+			rdata, err := hex.DecodeString(rr.Rdata)
+			if err != nil || len(rdata) < 4 {
+				continue
+			}
+			uri, err := url.Parse(string(rdata[4:]))
+			if err != nil {
+				continue
+			}
+			target = uri.Host
+			if host, _, err := net.SplitHostPort(target); err == nil {
+				target = host
+			}
+		default:
+			continue
+		}
+
+		if _, ok := dns.IsDomainName(target); !ok || target == "." || net.ParseIP(target) != nil {
+			continue
+		}
+
+		target = dns.Fqdn(target)
+		for _, rrType := range rrTypes {
+			key := target + "/" + dns.TypeToString[rrType]
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			records, err := r.LookupAnswersForType(target, rrType)
+			if err != nil {
+				debugMsg("Error looking up additional records for "+target+": ", err)
+				continue
+			}
+
+			extra = append(extra, records...)
+		}
+	}
+
+	return
+}
+
+// substituteDNAME answers a query for a name beneath the owner of the given
+// DNAME record, as described in RFC 6672. The DNAME is returned along with a
+// CNAME synthesized from the query name, and the rewritten name is then looked
+// up to fill in any further answers we know about.
+func (r *Resolver) substituteDNAME(req *dns.Msg, msg *dns.Msg, dname *dns.DNAME, client net.IP, depth int) {
+	q := req.Question[0]
+	owner := dname.Hdr.Name
+
+	target := q.Name[:len(q.Name)-len(owner)] + dname.Target
+	if _, ok := dns.IsDomainName(target); !ok || len(target) > 255 {
+		debugMsg("DNAME substitution of " + q.Name + " produced an invalid name")
+		msg.SetRcode(req, dns.RcodeYXDomain)
+		return
+	}
+
+	debugMsg("Rewriting " + q.Name + " to " + target + " using DNAME at " + owner)
+
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    dname.Hdr.Ttl},
+		Target: target}
+
+	msg.Answer = append(msg.Answer, dname, cname)
+
+	if q.Qtype == dns.TypeCNAME || depth >= maxChainDepth {
+		return
+	}
+
+	rewritten := new(dns.Msg)
+	rewritten.SetQuestion(target, q.Qtype)
+	rewritten.Question[0].Qclass = q.Qclass
+
+	chased := r.lookup(rewritten, client, depth+1)
+	msg.Answer = append(msg.Answer, chased.Answer...)
+
+	// The response code and authority reflect the last name in the chain
+	// (RFC 6604), unless it's outside of our zones and the client has to
+	// follow the CNAME itself
+	if chased.Rcode != dns.RcodeRefused {
+		msg.SetRcode(req, chased.Rcode)
+		msg.Ns = chased.Ns
+	}
+}
+
+// NameExists returns true if the given domain exists in etcd, either because
+// it holds records of its own or because it's an empty non-terminal with
+// names beneath it.
+func (r *Resolver) NameExists(name string) (exists bool, err error) {
+	key := nameToKey(strings.ToLower(name), "")
+	if len(key) == 0 {
+		return
+	}
+
+	// Names with SkyDNS services exist too, even without records of our own
+	prefixes := []string{r.etcdPrefix}
+	if len(r.skydnsPrefix) > 0 {
+		prefixes = append(prefixes, r.skydnsPrefix)
+	}
+
+	for _, prefix := range prefixes {
+		debugMsg("Checking etcd for existence of " + prefix + key)
+
+		_, err = r.etcd.Get(prefix+key, false, false)
+		if err == nil {
+			return true, nil
+		} else if e, ok := err.(*etcd.EtcdError); !ok || e.ErrorCode != 100 {
+			return
+		}
+	}
+
+	return false, nil
+}
+
+// StoredTypes returns the types of the records stored at the given name, as
+// found by listing its keys, along with the types that could be synthesized
+// from SkyDNS services if they're enabled.
+func (r *Resolver) StoredTypes(name string) (rrTypes []uint16, err error) {
+	key := nameToKey(strings.ToLower(name), "")
+	seen := make(map[uint16]bool)
+
+	debugMsg("Querying etcd for the types stored at " + key)
+
+	response, err := r.etcd.Get(r.etcdPrefix+key, false, false)
+	if err == nil {
+		for _, node := range response.Node.Nodes {
+			typeStr := path.Base(node.Key)
+			if !strings.HasPrefix(typeStr, ".") {
+				continue
+			}
+
+			rrType, ok := stringToType(typeStr[1:])
+			if ok && storableType(rrType) && !seen[rrType] {
+				rrTypes = append(rrTypes, rrType)
+				seen[rrType] = true
+			}
+		}
+	} else if e, ok := err.(*etcd.EtcdError); !ok || e.ErrorCode != 100 {
+		return nil, err
+	}
+
+	if len(r.skydnsPrefix) > 0 {
+		for rrType := range skydnsTypes {
+			if !seen[rrType] {
+				rrTypes = append(rrTypes, rrType)
+			}
+		}
+	}
+
+	return rrTypes, nil
+}
+
+// ClosestEncloser returns the longest existing ancestor of the given domain,
+// as defined in RFC 4592. An empty string is returned when no ancestor exists.
+func (r *Resolver) ClosestEncloser(name string) (encloser string, err error) {
+	parts := strings.Split(dns.Fqdn(name), ".")
+	for level := 1; level < len(parts); level++ {
+		domain := strings.Join(parts[level:], ".")
+		if len(domain) <= 1 {
+			break
+		}
+
+		exists, err := r.NameExists(domain)
+		if err != nil {
+			return "", err
+		}
+
+		if exists {
+			return domain, nil
+		}
 	}
 
 	return
@@ -240,6 +587,12 @@ func gatherFromChannels(rrsIn chan dns.RR, errsIn chan error) (rrs []dns.RR, err
 // to do the work, when using this function one should use a WaitGroup to know when all work
 // has been completed.
 func (r *Resolver) AnswerQuestion(q dns.Question) (answers chan dns.RR, errors chan error) {
+	return r.answerQuestion(q, nil)
+}
+
+// answerQuestion is the same as AnswerQuestion, for a question on behalf of
+// the given client, which is used to choose answers for that client.
+func (r *Resolver) answerQuestion(q dns.Question, client net.IP) (answers chan dns.RR, errors chan error) {
 	answers = make(chan dns.RR)
 	errors = make(chan error)
 
@@ -250,35 +603,71 @@ func (r *Resolver) AnswerQuestion(q dns.Question) (answers chan dns.RR, errors c
 	debugMsg("Answering question ", q)
 
 	if q.Qtype == dns.TypeANY {
-		wg := sync.WaitGroup{}
-		wg.Add(len(converters))
 		go func() {
-			wg.Wait()
-			close(answers)
-			close(errors)
-		}()
-		for rrType, _ := range converters {
-			go func(rrType uint16) {
-				defer func() { recover() }()
-				defer wg.Done()
+			defer func() {
+				close(answers)
+				close(errors)
+			}()
 
-				results, err := r.LookupAnswersForType(q.Name, rrType)
-				if err != nil {
-					errors <- err
-				} else {
-					for _, answer := range results {
-						answers <- answer
+			rrTypes, err := r.StoredTypes(q.Name)
+			if err != nil {
+				errors <- err
+				return
+			}
+
+			stored := make(map[uint16]bool)
+			for _, rrType := range rrTypes {
+				stored[rrType] = true
+			}
+
+			wg := sync.WaitGroup{}
+			wg.Add(len(rrTypes))
+			if r.genericRecords {
+				// Generic records of types stored the usual way will be
+				// found along with the rest of that type, so only look
+				// for others
+				wg.Add(1)
+				go func() {
+					defer func() { recover() }()
+					defer wg.Done()
+
+					results, err := r.LookupGenericRecords(q.Name, q.Qtype)
+					if err != nil {
+						errors <- err
+					} else {
+						for _, answer := range results {
+							if !stored[answer.Header().Rrtype] {
+								answers <- answer
+							}
+						}
 					}
-				}
-			}(rrType)
-		}
-	} else if _, ok := converters[q.Qtype]; ok {
+				}()
+			}
+			for _, rrType := range rrTypes {
+				go func(rrType uint16) {
+					defer func() { recover() }()
+					defer wg.Done()
+
+					results, err := r.lookupAnswersForType(q.Name, rrType, client)
+					if err != nil {
+						errors <- err
+					} else {
+						for _, answer := range results {
+							answers <- answer
+						}
+					}
+				}(rrType)
+			}
+
+			wg.Wait()
+		}()
+	} else if storableType(q.Qtype) {
 		go func() {
 			defer func() {
 				close(answers)
 				close(errors)
 			}()
-			records, err := r.LookupAnswersForType(q.Name, q.Qtype)
+			records, err := r.lookupAnswersForType(q.Name, q.Qtype, client)
 			if err != nil {
 				errors <- err
 			} else {
@@ -287,7 +676,7 @@ func (r *Resolver) AnswerQuestion(q dns.Question) (answers chan dns.RR, errors c
 						answers <- rr
 					}
 				} else {
-					cnames, err := r.LookupAnswersForType(q.Name, dns.TypeCNAME)
+					cnames, err := r.lookupAnswersForType(q.Name, dns.TypeCNAME, client)
 					if err != nil {
 						errors <- err
 					} else {
@@ -297,6 +686,15 @@ func (r *Resolver) AnswerQuestion(q dns.Question) (answers chan dns.RR, errors c
 								AttemptedType: dns.TypeCNAME}
 						} else if len(cnames) > 0 {
 							answers <- cnames[0]
+						} else if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
+							aliased, err := r.LookupAlias(q.Name, q.Qtype)
+							if err != nil {
+								errors <- err
+							} else {
+								for _, rr := range aliased {
+									answers <- rr
+								}
+							}
 						}
 					}
 				}
@@ -312,35 +710,478 @@ func (r *Resolver) AnswerQuestion(q dns.Question) (answers chan dns.RR, errors c
 }
 
 func (r *Resolver) LookupAnswersForType(name string, rrType uint16) (answers []dns.RR, err error) {
+	return r.lookupAnswersForType(name, rrType, nil)
+}
+
+// lookupAnswersForType returns the records of the given type at the given name,
+// ordered for the given client (which may be nil) and limited to the maximum
+// number of answers.
+func (r *Resolver) lookupAnswersForType(name string, rrType uint16, client net.IP) (answers []dns.RR, err error) {
+	converter, ok := converters[rrType]
+	if !ok {
+		converter = convertGeneric
+	}
+
+	answers, settings, err := r.lookupRecords(name, "/."+typeToString(rrType), rrType, converter)
+	if err != nil {
+		return
+	}
+
+	if r.genericRecords {
+		generic, err := r.LookupGenericRecords(name, rrType)
+		if err != nil {
+			return nil, err
+		}
+
+		answers = append(answers, generic...)
+	}
+
+	if len(r.skydnsPrefix) > 0 {
+		services, err := r.LookupSkyDNS(name, rrType)
+		if err != nil {
+			return nil, err
+		}
+
+		answers = append(answers, services...)
+	}
+
+	if len(answers) > 0 {
+		if settings == nil {
+			found := r.RecordSettings(name, typeToString(rrType))
+			settings = &found
+		}
+
+		answers = r.OrderAnswers(name, rrType, answers, *settings, client)
+	}
+
+	return
+}
+
+// LookupGenericRecords returns the records of the given type stored in zone
+// file presentation format under the .RR key of the given name. Records of
+// every type are returned when the given type is ANY.
+func (r *Resolver) LookupGenericRecords(name string, rrType uint16) (answers []dns.RR, err error) {
+	records, _, err := r.lookupRecords(name, "/.RR", rrType, convertPresentation)
+	if err != nil {
+		return
+	}
+
+	for _, rr := range records {
+		if rrType == dns.TypeANY || rr.Header().Rrtype == rrType {
+			answers = append(answers, rr)
+		}
+	}
+
+	return
+}
+
+// lookupRecords converts each of the nodes stored beneath the given name and
+// key suffix into a record, using the given converter. The settings used for
+// the records are returned too, or nil if there aren't any records.
+func (r *Resolver) lookupRecords(name string, suffix string, rrType uint16,
+	converter func(node *etcd.Node, header dns.RR_Header) (dns.RR, error)) (answers []dns.RR, settings *RecordSettings, err error) {
 	name = strings.ToLower(name)
 
-	typeStr := dns.TypeToString[rrType]
-	nodes, err := r.GetFromStorage(nameToKey(name, "/."+typeStr))
+	nodes, err := r.GetFromStorage(nameToKey(name, suffix))
 
 	if err != nil {
 		if e, ok := err.(*etcd.EtcdError); ok {
 			if e.ErrorCode == 100 {
-				return answers, nil
+				return answers, nil, nil
 			}
 		}
 
 		return
 	}
 
+	// The type is named by the last part of the suffix (e.g /.geo/us/.A)
+	typeStr := suffix[strings.LastIndex(suffix, "/.")+2:]
+	if len(nodes) == 0 {
+		return
+	}
+
+	found := r.RecordSettings(name, typeStr)
+	settings = &found
+
+	weights := make([]uint32, len(nodes))
+	weighted := false
+
 	answers = make([]dns.RR, len(nodes))
 	for i, node := range nodes {
 
+		weights[i] = 1
+		if weight, ok := recordWeight(node, typeStr); ok {
+			weights[i], weighted = weight, true
+		}
+
+		if node.defaultTtl {
+			node.ttl = settings.DefaultTtl
+		}
+		node.ttl = expiringTtl(settings.Clamp(node.ttl, node.node.Key), node.node)
+
+		value, err := recordNode(node, typeStr, rrType)
+		if err != nil {
+			debugMsg("Error converting type: ", err)
+			return nil, nil, err
+		}
+
 		header := dns.RR_Header{Name: name, Class: dns.ClassINET, Rrtype: rrType, Ttl: node.ttl}
-		answer, err := converters[rrType](node.node, header)
+		answer, err := converter(value, header)
 
 		if err != nil {
 			debugMsg("Error converting type: ", err)
-			return nil, err
+			return nil, nil, err
 		}
 
 		answers[i] = answer
 	}
 
+	answers, weights = r.activeAnswers(nodes, answers, weights)
+
+	if weighted {
+		// Answers ordered by hashing the client are limited once they're
+		// ordered, otherwise the answers picked are the ones returned
+		max := int(settings.MaxAnswers)
+		if settings.Order == OrderHash {
+			max = 0
+		}
+
+		answers = r.SelectAnswers(answers, weights, max)
+	}
+
+	return
+}
+
+// RecordSettings holds the TTL policy and limits for a set of records
+type RecordSettings struct {
+	DefaultTtl uint32
+	MinTtl     uint32
+	MaxTtl     uint32 // Zero for no maximum
+	MaxAnswers uint32 // Zero for no maximum
+	Order      string
+}
+
+// RecordSettings returns the TTL policy and limits for records of the given
+// type (named as in etcd keys) at the given name. A default TTL for records
+// without one of their own can be set for a domain and everything beneath it
+// with a .ttl key, or a .ttl.<TYPE> key for only records of that type.
+// Likewise, .minttl and .maxttl keys set the bounds TTLs are clamped to,
+// .maxanswers.<TYPE> keys (or .maxanswers, for A and AAAA records) limit the
+// number of records returned, and .order (or .order.<TYPE>) keys set the
+// order they're returned in. The setting closest
+// to the name applies (preferring one for the type at the same level), and
+// otherwise the global setting is used.
+func (r *Resolver) RecordSettings(name string, typeStr string) (settings RecordSettings) {
+	settings = RecordSettings{DefaultTtl: r.defaultTtl, MinTtl: r.minTtl, MaxTtl: r.maxTtl, Order: r.answerOrder}
+	found := make(map[string]bool)
+
+	r.walkSettings(name, func(settingValues map[string]string) bool {
+		for _, setting := range []string{".order." + typeStr, ".order"} {
+			if order, ok := settingValues[setting]; ok && !found[".order"] {
+				if validOrder(order) {
+					settings.Order, found[".order"] = order, true
+				} else {
+					debugMsg("Unknown answer order '" + order + "' in " + setting)
+				}
+			}
+		}
+
+		values := make(map[string]uint32)
+		for setting, value := range settingValues {
+			if !strings.HasPrefix(setting, ".ttl") && setting != ".minttl" && setting != ".maxttl" && !strings.HasPrefix(setting, ".maxanswers") {
+				continue
+			}
+
+			ttlValue, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				debugMsg("Unable to convert "+setting+" value to int: ", value)
+				continue
+			}
+
+			values[setting] = uint32(ttlValue)
+		}
+
+		if !found[".ttl"] {
+			if ttl, ok := values[".ttl."+typeStr]; ok {
+				settings.DefaultTtl, found[".ttl"] = ttl, true
+			} else if ttl, ok := values[".ttl"]; ok {
+				settings.DefaultTtl, found[".ttl"] = ttl, true
+			}
+		}
+
+		if ttl, ok := values[".minttl"]; ok && !found[".minttl"] {
+			settings.MinTtl, found[".minttl"] = ttl, true
+		}
+
+		if ttl, ok := values[".maxttl"]; ok && !found[".maxttl"] {
+			settings.MaxTtl, found[".maxttl"] = ttl, true
+		}
+
+		// A limit for every type only applies to addresses, as limiting NS
+		// or MX records (say) to fewer than are published is rarely wanted
+		if !found[".maxanswers"] {
+			if max, ok := values[".maxanswers."+typeStr]; ok {
+				settings.MaxAnswers, found[".maxanswers"] = max, true
+			} else if max, ok := values[".maxanswers"]; ok && (typeStr == "A" || typeStr == "AAAA") {
+				settings.MaxAnswers, found[".maxanswers"] = max, true
+			}
+		}
+
+		return len(found) < 5
+	})
+
+	return
+}
+
+// walkSettings calls the given function with the settings stored at the given
+// name and then each of its parents in turn, until it returns false. Settings
+// are the values of any keys beneath a domain that aren't records or
+// subdomains, such as .ttl, keyed by their name.
+func (r *Resolver) walkSettings(name string, visit func(values map[string]string) bool) {
+	labels := dns.SplitDomainName(name)
+	for i := 0; i <= len(labels); i++ {
+		key := r.etcdPrefix + nameToKey(strings.Join(labels[i:], "."), "")
+
+		values, ok := r.settingsAt(key)
+		if !ok {
+			continue
+		}
+
+		if !visit(values) {
+			return
+		}
+	}
+}
+
+// settingsAt returns the settings stored at the given key, from the settings
+// cache if the resolver has one. False is returned if the key doesn't exist.
+func (r *Resolver) settingsAt(key string) (values map[string]string, ok bool) {
+	if r.settingsCache != nil {
+		if values, ok := r.settingsCache.Get(key); ok {
+			return values, values != nil
+		}
+	}
+
+	debugMsg("Querying etcd for settings at " + key)
+	response, err := r.etcd.Get(key, false, false)
+	if err == nil {
+		values = make(map[string]string)
+		for _, node := range response.Node.Nodes {
+			setting := path.Base(node.Key)
+			if !node.Dir && strings.HasPrefix(setting, ".") {
+				values[setting] = node.Value
+			}
+		}
+	} else if e, ok := err.(*etcd.EtcdError); !ok || e.ErrorCode != 100 {
+		// Don't cache the lack of settings if etcd couldn't tell us
+		return nil, false
+	}
+
+	if r.settingsCache != nil {
+		r.settingsCache.Set(key, values)
+	}
+
+	return values, values != nil
+}
+
+// Clamp returns the given TTL of the record with the given key, within the
+// bounds of the settings.
+func (s RecordSettings) Clamp(ttl uint32, key string) uint32 {
+	if ttl < s.MinTtl {
+		counter := metrics.GetOrRegisterCounter("resolver.ttl.clamped_min", metrics.DefaultRegistry)
+		counter.Inc(1)
+		debugMsg(fmt.Sprintf("Raising TTL of %s from %d to the minimum of %d", key, ttl, s.MinTtl))
+		return s.MinTtl
+	}
+
+	if s.MaxTtl > 0 && ttl > s.MaxTtl {
+		counter := metrics.GetOrRegisterCounter("resolver.ttl.clamped_max", metrics.DefaultRegistry)
+		counter.Inc(1)
+		debugMsg(fmt.Sprintf("Lowering TTL of %s from %d to the maximum of %d", key, ttl, s.MaxTtl))
+		return s.MaxTtl
+	}
+
+	return ttl
+}
+
+// expiringTtl caps the given TTL to the remaining lifetime of the given node
+// if its key is due to expire, so the record is never cached for longer than
+// it exists. The lifetime etcd reports is used rather than the expiration
+// time, which would depend on our clock agreeing with etcd's.
+func expiringTtl(ttl uint32, node *etcd.Node) uint32 {
+	if node.Expiration == nil {
+		return ttl
+	}
+
+	remaining := node.TTL
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	if uint64(remaining) < uint64(ttl) {
+		counter := metrics.GetOrRegisterCounter("resolver.ttl.clamped_expiry", metrics.DefaultRegistry)
+		counter.Inc(1)
+		debugMsg(fmt.Sprintf("Lowering TTL of %s from %d to %d as the key expires", node.Key, ttl, remaining))
+		return uint32(remaining)
+	}
+
+	return ttl
+}
+
+// LookupAlias looks for an ALIAS record on the given name, and if one exists
+// resolves its target to records of the given type. The target is looked up in
+// etcd when it falls within a zone we're authoritative for, otherwise the
+// configured upstream nameserver is asked. The records returned take the name
+// of the ALIAS owner, with TTLs clamped to the lower of the two.
+func (r *Resolver) LookupAlias(name string, rrType uint16) (answers []dns.RR, err error) {
+	return r.lookupAlias(name, rrType, 0)
+}
+
+// lookupAlias is the same as LookupAlias, for an ALIAS found after following
+// the given number of ALIAS and CNAME records.
+func (r *Resolver) lookupAlias(name string, rrType uint16, depth int) (answers []dns.RR, err error) {
+	name = strings.ToLower(name)
+
+	nodes, err := r.GetFromStorage(nameToKey(name, "/.ALIAS"))
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok {
+			if e.ErrorCode == 100 {
+				return answers, nil
+			}
+		}
+
+		return
+	}
+
+	if len(nodes) == 0 {
+		return
+	} else if len(nodes) > 1 {
+		return nil, &RecordValueError{
+			Message:       "Multiple ALIAS records is invalid",
+			AttemptedType: rrType}
+	}
+
+	node := nodes[0]
+	settings := r.RecordSettings(name, "ALIAS")
+	if node.defaultTtl {
+		node.ttl = settings.DefaultTtl
+	}
+	node.ttl = expiringTtl(settings.Clamp(node.ttl, node.node.Key), node.node)
+
+	value, err := recordNode(node, "ALIAS", rrType)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, ok := dns.IsDomainName(value.Value)
+	if !ok || labels == 0 {
+		return nil, &NodeConversionError{
+			Node:          node.node,
+			Message:       fmt.Sprintf("ALIAS target '%s' isn't a valid domain name", value.Value),
+			AttemptedType: rrType}
+	}
+
+	target := dns.Fqdn(value.Value)
+	debugMsg("Resolving ALIAS for " + name + " to " + target)
+
+	records, err := r.resolveAliasTarget(target, rrType, depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rr := range records {
+		header := rr.Header()
+		header.Name = name
+		if header.Ttl > node.ttl {
+			header.Ttl = node.ttl
+		}
+
+		answers = append(answers, rr)
+	}
+
+	return
+}
+
+// resolveAliasTarget returns the records of the given type for the target of
+// an ALIAS, found after following the given number of ALIAS and CNAME records.
+// Targets within our zones can themselves be a CNAME or ALIAS, which are
+// followed until records are found, up to maxChainDepth of them.
+func (r *Resolver) resolveAliasTarget(target string, rrType uint16, depth int) (records []dns.RR, err error) {
+	if depth > maxChainDepth {
+		return nil, &RecordValueError{
+			Message:       "Too many ALIAS and CNAME records to follow for " + target,
+			AttemptedType: rrType}
+	}
+
+	if len(r.aliasUpstream) > 0 && r.Authority(target) == nil {
+		return r.lookupUpstream(target, rrType)
+	}
+
+	records, err = r.LookupAnswersForType(target, rrType)
+	if err != nil || len(records) > 0 {
+		return
+	}
+
+	cnames, err := r.LookupAnswersForType(target, dns.TypeCNAME)
+	if err != nil {
+		return nil, err
+	} else if len(cnames) > 1 {
+		return nil, &RecordValueError{
+			Message:       "Multiple CNAME records is invalid",
+			AttemptedType: dns.TypeCNAME}
+	} else if len(cnames) == 0 {
+		return r.lookupAlias(target, rrType, depth)
+	}
+
+	cname := cnames[0].(*dns.CNAME)
+	debugMsg("Following CNAME for ALIAS target " + target + " to " + cname.Target)
+
+	records, err = r.resolveAliasTarget(cname.Target, rrType, depth+1)
+	for _, rr := range records {
+		if rr.Header().Ttl > cname.Hdr.Ttl {
+			rr.Header().Ttl = cname.Hdr.Ttl
+		}
+	}
+
+	return
+}
+
+// lookupUpstream asks the configured upstream nameserver for records of the
+// given type, ignoring anything else (such as CNAMEs) in the answer.
+func (r *Resolver) lookupUpstream(name string, rrType uint16) (answers []dns.RR, err error) {
+	counter := metrics.GetOrRegisterCounter("resolver.upstream.query_count", metrics.DefaultRegistry)
+	error_counter := metrics.GetOrRegisterCounter("resolver.upstream.query_error_count", metrics.DefaultRegistry)
+
+	counter.Inc(1)
+	debugMsg("Querying upstream " + r.aliasUpstream + " for " + name)
+
+	query := new(dns.Msg)
+	query.SetQuestion(name, rrType)
+
+	client := &dns.Client{
+		DialTimeout:  time.Duration(2) * time.Second,
+		ReadTimeout:  time.Duration(2) * time.Second,
+		WriteTimeout: time.Duration(2) * time.Second}
+
+	response, _, err := client.Exchange(query, r.aliasUpstream)
+	if err != nil {
+		error_counter.Inc(1)
+		return
+	}
+
+	if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+		error_counter.Inc(1)
+		return nil, &RecordValueError{
+			Message:       fmt.Sprintf("Upstream returned %s for %s", dns.RcodeToString[response.Rcode], name),
+			AttemptedType: rrType}
+	}
+
+	for _, rr := range response.Answer {
+		if rr.Header().Rrtype == rrType {
+			answers = append(answers, rr)
+		}
+	}
+
 	return
 }
 
@@ -361,6 +1202,57 @@ func nameToKey(name string, suffix string) string {
 	return keyBuffer.String()
 }
 
+// rawRR returns a record holding the given wire format rdata, as described in
+// RFC 3597. This is used for record types the DNS library doesn't support.
+func rawRR(header dns.RR_Header, rdata []byte) dns.RR {
+	return &dns.RFC3597{Hdr: header, Rdata: hex.EncodeToString(rdata)}
+}
+
+// splitTxtString splits a TXT value into strings of at most 255 bytes, the
+// longest a single character-string can be. Escape sequences such as \DDD are
+// counted as the single byte they represent and are never split.
+func splitTxtString(value string) (txt []string) {
+	start, length := 0, 0
+	for i := 0; i < len(value); i++ {
+		end := i + escapeLength(value, i)
+		if length == 255 {
+			txt = append(txt, value[start:i])
+			start, length = i, 0
+		}
+		length++
+		i = end - 1
+	}
+
+	return append(txt, value[start:])
+}
+
+// txtStringLength returns the length of the given TXT string once escape
+// sequences have been decoded.
+func txtStringLength(str string) (length int) {
+	for i := 0; i < len(str); i += escapeLength(str, i) {
+		length++
+	}
+	return
+}
+
+// escapeLength returns the number of characters of the string starting at the
+// given offset that represent a single byte on the wire.
+func escapeLength(str string, i int) int {
+	if str[i] != '\\' || i+1 == len(str) {
+		return 1
+	}
+
+	if i+3 < len(str) && isDigit(str[i+1]) && isDigit(str[i+2]) && isDigit(str[i+3]) {
+		return 4
+	}
+
+	return 2
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
 // Map of conversion functions that turn individual etcd nodes into dns.RR answers
 var converters = map[uint16]func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error){
 
@@ -406,7 +1298,27 @@ var converters = map[uint16]func(node *etcd.Node, header dns.RR_Header) (rr dns.
 	},
 
 	dns.TypeTXT: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
-		rr = &dns.TXT{header, []string{node.Value}}
+		// Each tab-delimited part of the value is a separate string, and
+		// anything longer than a single character-string allows is split
+		var txt []string
+		for _, part := range strings.Split(node.Value, "\t") {
+			txt = append(txt, splitTxtString(part)...)
+		}
+
+		length := 0
+		for _, str := range txt {
+			length += 1 + txtStringLength(str)
+		}
+
+		if length > 65535 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value is too long for a TXT record (%d bytes)", length),
+				AttemptedType: dns.TypeTXT}
+			return
+		}
+
+		rr = &dns.TXT{header, txt}
 		return
 	},
 
@@ -415,6 +1327,20 @@ var converters = map[uint16]func(node *etcd.Node, header dns.RR_Header) (rr dns.
 		return
 	},
 
+	dns.TypeDNAME: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+		labels, ok := dns.IsDomainName(node.Value)
+
+		if ok && labels > 0 {
+			rr = &dns.DNAME{Hdr: header, Target: dns.Fqdn(node.Value)}
+		} else {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value '%s' isn't a valid domain name", node.Value),
+				AttemptedType: dns.TypeDNAME}
+		}
+		return
+	},
+
 	dns.TypeNS: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
 		rr = &dns.NS{header, dns.Fqdn(node.Value)}
 		return
@@ -471,6 +1397,305 @@ var converters = map[uint16]func(node *etcd.Node, header dns.RR_Header) (rr dns.
 		return
 	},
 
+	dns.TypeMX: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+		parts := strings.SplitN(node.Value, "\t", 2)
+
+		if len(parts) != 2 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value %s isn't valid for MX", node.Value),
+				AttemptedType: dns.TypeMX}
+			return
+		}
+
+		preference, e := strconv.ParseUint(parts[0], 10, 16)
+		if e != nil {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Preference '%s' isn't a 16bit unsigned int", parts[0]),
+				AttemptedType: dns.TypeMX}
+			return
+		}
+
+		labels, ok := dns.IsDomainName(parts[1])
+		if !ok || labels == 0 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Exchange '%s' isn't a valid domain name", parts[1]),
+				AttemptedType: dns.TypeMX}
+			return
+		}
+
+		rr = &dns.MX{
+			Hdr:        header,
+			Preference: uint16(preference),
+			Mx:         dns.Fqdn(parts[1])}
+		return
+	},
+
+	dns.TypeCAA: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+		parts := strings.SplitN(node.Value, "\t", 3)
+
+		if len(parts) != 3 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value %s isn't valid for CAA", node.Value),
+				AttemptedType: dns.TypeCAA}
+			return
+		}
+
+		flag, e := strconv.ParseUint(parts[0], 10, 8)
+		if e != nil {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Flags '%s' isn't an 8bit unsigned int", parts[0]),
+				AttemptedType: dns.TypeCAA}
+			return
+		}
+
+		tag := strings.ToLower(parts[1])
+		value := parts[2]
+
+		switch tag {
+		case "issue", "issuewild":
+			// The value is an (optional) issuer domain name, followed by
+			// optional semicolon separated parameters
+			issuer := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+			if len(issuer) > 0 {
+				labels, ok := dns.IsDomainName(issuer)
+				if !ok || labels == 0 {
+					err = &NodeConversionError{
+						Node:          node,
+						Message:       fmt.Sprintf("Issuer '%s' isn't a valid domain name", issuer),
+						AttemptedType: dns.TypeCAA}
+					return
+				}
+			}
+		case "iodef":
+			iodef, e := url.Parse(value)
+			if e != nil || (iodef.Scheme != "mailto" && iodef.Scheme != "http" && iodef.Scheme != "https") {
+				err = &NodeConversionError{
+					Node:          node,
+					Message:       fmt.Sprintf("Value '%s' isn't a mailto, http or https URL", value),
+					AttemptedType: dns.TypeCAA}
+				return
+			}
+		default:
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Tag '%s' isn't one of issue, issuewild or iodef", parts[1]),
+				AttemptedType: dns.TypeCAA}
+			return
+		}
+
+		// The version of the DNS library we use can't represent CAA records,
+		// so we build the wire format ourselves
+		rdata := []byte{uint8(flag), uint8(len(tag))}
+		rdata = append(rdata, tag...)
+		rdata = append(rdata, value...)
+
+		rr = rawRR(header, rdata)
+		return
+	},
+
+	dns.TypeSSHFP: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+		parts := strings.SplitN(node.Value, "\t", 3)
+
+		if len(parts) != 3 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value %s isn't valid for SSHFP", node.Value),
+				AttemptedType: dns.TypeSSHFP}
+			return
+		}
+
+		fields := make([]uint8, 2)
+		for i, name := range []string{"Algorithm", "Type"} {
+			field, e := strconv.ParseUint(parts[i], 10, 8)
+			if e != nil {
+				err = &NodeConversionError{
+					Node:          node,
+					Message:       fmt.Sprintf("%s '%s' isn't an 8bit unsigned int", name, parts[i]),
+					AttemptedType: dns.TypeSSHFP}
+				return
+			}
+			fields[i] = uint8(field)
+		}
+
+		fingerprint, e := hex.DecodeString(parts[2])
+		if e != nil || len(fingerprint) == 0 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Fingerprint '%s' isn't a valid hex string", parts[2]),
+				AttemptedType: dns.TypeSSHFP}
+			return
+		}
+
+		rr = &dns.SSHFP{
+			Hdr:         header,
+			Algorithm:   fields[0],
+			Type:        fields[1],
+			FingerPrint: hex.EncodeToString(fingerprint)}
+		return
+	},
+
+	dns.TypeTLSA: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+		parts := strings.SplitN(node.Value, "\t", 4)
+
+		if len(parts) != 4 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value %s isn't valid for TLSA", node.Value),
+				AttemptedType: dns.TypeTLSA}
+			return
+		}
+
+		fields := make([]uint8, 3)
+		for i, name := range []string{"Usage", "Selector", "Matching type"} {
+			field, e := strconv.ParseUint(parts[i], 10, 8)
+			if e != nil {
+				err = &NodeConversionError{
+					Node:          node,
+					Message:       fmt.Sprintf("%s '%s' isn't an 8bit unsigned int", name, parts[i]),
+					AttemptedType: dns.TypeTLSA}
+				return
+			}
+			fields[i] = uint8(field)
+		}
+
+		certificate, e := hex.DecodeString(parts[3])
+		if e != nil || len(certificate) == 0 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Certificate association data '%s' isn't a valid hex string", parts[3]),
+				AttemptedType: dns.TypeTLSA}
+			return
+		}
+
+		rr = &dns.TLSA{
+			Hdr:          header,
+			Usage:        fields[0],
+			Selector:     fields[1],
+			MatchingType: fields[2],
+			Certificate:  hex.EncodeToString(certificate)}
+		return
+	},
+
+	dns.TypeNAPTR: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+		parts := strings.SplitN(node.Value, "\t", 6)
+
+		if len(parts) != 6 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value %s isn't valid for NAPTR", node.Value),
+				AttemptedType: dns.TypeNAPTR}
+			return
+		}
+
+		fields := make([]uint16, 2)
+		for i, name := range []string{"Order", "Preference"} {
+			field, e := strconv.ParseUint(parts[i], 10, 16)
+			if e != nil {
+				err = &NodeConversionError{
+					Node:          node,
+					Message:       fmt.Sprintf("%s '%s' isn't a 16bit unsigned int", name, parts[i]),
+					AttemptedType: dns.TypeNAPTR}
+				return
+			}
+			fields[i] = uint16(field)
+		}
+
+		flags := parts[2]
+		for _, c := range flags {
+			if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+				err = &NodeConversionError{
+					Node:          node,
+					Message:       fmt.Sprintf("Flags '%s' must be alphanumeric", flags),
+					AttemptedType: dns.TypeNAPTR}
+				return
+			}
+		}
+
+		regexp := parts[4]
+		replacement := parts[5]
+		if len(replacement) == 0 {
+			replacement = "."
+		}
+
+		labels, ok := dns.IsDomainName(replacement)
+		if !ok || (labels == 0 && replacement != ".") {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Replacement '%s' isn't a valid domain name", replacement),
+				AttemptedType: dns.TypeNAPTR}
+			return
+		} else if len(regexp) > 0 && replacement != "." {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       "Only one of regexp and replacement can be given",
+				AttemptedType: dns.TypeNAPTR}
+			return
+		}
+
+		rr = &dns.NAPTR{
+			Hdr:         header,
+			Order:       fields[0],
+			Preference:  fields[1],
+			Flags:       flags,
+			Service:     parts[3],
+			Regexp:      regexp,
+			Replacement: dns.Fqdn(replacement)}
+		return
+	},
+
+	dns.TypeURI: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+		parts := strings.SplitN(node.Value, "\t", 3)
+
+		if len(parts) != 3 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Value %s isn't valid for URI", node.Value),
+				AttemptedType: dns.TypeURI}
+			return
+		}
+
+		fields := make([]uint16, 2)
+		for i, name := range []string{"Priority", "Weight"} {
+			field, e := strconv.ParseUint(parts[i], 10, 16)
+			if e != nil {
+				err = &NodeConversionError{
+					Node:          node,
+					Message:       fmt.Sprintf("%s '%s' isn't a 16bit unsigned int", name, parts[i]),
+					AttemptedType: dns.TypeURI}
+				return
+			}
+			fields[i] = uint16(field)
+		}
+
+		target, e := url.Parse(parts[2])
+		if e != nil || len(target.Scheme) == 0 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       fmt.Sprintf("Target '%s' isn't a valid URI", parts[2]),
+				AttemptedType: dns.TypeURI}
+			return
+		}
+
+		// The version of the DNS library we use encodes the URI target as a
+		// character string, which isn't what RFC 7553 describes, so we build
+		// the wire format ourselves
+		rdata := []byte{
+			uint8(fields[0] >> 8), uint8(fields[0]),
+			uint8(fields[1] >> 8), uint8(fields[1])}
+		rdata = append(rdata, parts[2]...)
+
+		rr = rawRR(header, rdata)
+		return
+	},
+
+	TypeSVCB:  convertSVCB,
+	TypeHTTPS: convertSVCB,
+
 	dns.TypeSOA: func(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
 		parts := strings.SplitN(node.Value, "\t", 6)
 
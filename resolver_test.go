@@ -1,8 +1,16 @@
 package main
 
 import (
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/miekg/dns"
@@ -400,12 +408,45 @@ func TestAnswerQuestionANY(t *testing.T) {
 	}
 }
 
+func TestAnswerQuestionANYGenericType(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionANYGenericType/"
+	client.Set("TestAnswerQuestionANYGenericType/net/disco/bar/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionANYGenericType/net/disco/bar/.A.ttl", "60", 0)
+	client.Set("TestAnswerQuestionANYGenericType/net/disco/bar/.LOC",
+		"52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m",
+		0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeANY)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 2 {
+		t.Error("Expected two answers, got ", len(answer.Answer))
+		t.Fatal()
+	}
+
+	types := make(map[uint16]bool)
+	for _, rr := range answer.Answer {
+		types[rr.Header().Rrtype] = true
+	}
+
+	if !types[dns.TypeA] || !types[dns.TypeLOC] {
+		t.Error("Expected A and LOC answers, got ", answer.Answer)
+		t.Fatal()
+	}
+}
+
 func TestAnswerQuestionUnsupportedType(t *testing.T) {
-	// query for a type that we don't have support for (I tried to pick the most
-	// obscure rr type that the dns library supports and that we're unlikely to
-	// add support for)
+	resolver.etcdPrefix = "TestAnswerQuestionUnsupportedType/"
+	client.Set("TestAnswerQuestionUnsupportedType/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	// query for a type that can't be stored (any type with record data can be
+	// stored generically, so this has to be a meta type)
 	query := new(dns.Msg)
-	query.SetQuestion("bar.disco.net.", dns.TypeEUI64)
+	query.SetQuestion("bar.disco.net.", dns.TypeMAILA)
 
 	answer := resolver.Lookup(query)
 
@@ -419,8 +460,13 @@ func TestAnswerQuestionUnsupportedType(t *testing.T) {
 		t.Fatal()
 	}
 
-	if len(answer.Ns) > 0 {
-		t.Error("Didn't expect any authority records")
+	if len(answer.Ns) != 1 {
+		t.Error("Expected one authority record, got ", len(answer.Ns))
+		t.Fatal()
+	}
+
+	if _, ok := answer.Ns[0].(*dns.SOA); !ok {
+		t.Error("Expected SOA authority record, got ", answer.Ns[0])
 	}
 }
 
@@ -562,270 +608,1376 @@ func TestAnswerQuestionWildcardAAAA(t *testing.T) {
 	}
 }
 
-func TestAnswerQuestionTTL(t *testing.T) {
-	resolver.etcdPrefix = "TestAnswerQuestionTTL/"
-	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A", "1.2.3.4", 0)
-	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A.ttl", "300", 0)
+func TestAnswerQuestionWildcardExistingName(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionWildcardExistingName/"
+	client.Set("TestAnswerQuestionWildcardExistingName/net/disco/*/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionWildcardExistingName/net/disco/bar/.TXT", "foo", 0)
+	client.Set("TestAnswerQuestionWildcardExistingName/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
-
-	if len(records) != 1 {
-		t.Error("Expected one answer, got ", len(records))
-		t.Fatal()
-	}
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeA)
 
-	rr := records[0].(*dns.A)
-	header := rr.Header()
+	answer := resolver.Lookup(query)
 
-	if header.Name != "bar.disco.net." {
-		t.Error("Expected record with name bar.disco.net.: ", header.Name)
-		t.Fatal()
-	}
-	if header.Rrtype != dns.TypeA {
-		t.Error("Expected record with type A:", header.Rrtype)
+	if len(answer.Answer) > 0 {
+		t.Error("Didn't expect any answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
-	if header.Ttl != 300 {
-		t.Error("Expected TTL of 300 seconds:", header.Ttl)
+
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
-	if rr.A.String() != "1.2.3.4" {
-		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
+
+	if len(answer.Ns) != 1 {
+		t.Error("Expected one authority record")
 		t.Fatal()
 	}
 }
 
-func TestAnswerQuestionTTLMultipleRecords(t *testing.T) {
-	resolver.etcdPrefix = "TestAnswerQuestionTTLMultipleRecords/"
-	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/0", "1.2.3.4", 0)
-	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/0.ttl", "300", 0)
-	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/1", "8.8.8.8", 0)
-	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/1.ttl", "600", 0)
+func TestAnswerQuestionWildcardEmptyNonTerminal(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionWildcardEmptyNonTerminal/"
+	client.Set("TestAnswerQuestionWildcardEmptyNonTerminal/net/disco/*/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionWildcardEmptyNonTerminal/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestAnswerQuestionWildcardEmptyNonTerminal/net/disco/bar/baz/.A", "2.3.4.5", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
-
-	if len(records) != 2 {
-		t.Error("Expected two answers, got ", len(records))
-		t.Fatal()
-	}
+	// The empty non-terminal itself exists, so should get no data
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeA)
 
-	rrOne := records[0].(*dns.A)
-	headerOne := rrOne.Header()
+	answer := resolver.Lookup(query)
 
-	if headerOne.Ttl != 300 {
-		t.Error("Expected TTL of 300 seconds:", headerOne.Ttl)
+	if len(answer.Answer) > 0 {
+		t.Error("Didn't expect any answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
-	if rrOne.A.String() != "1.2.3.4" {
-		t.Error("Expected A record to be 1.2.3.4: ", rrOne.A)
+
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
 
-	rrTwo := records[1].(*dns.A)
-	headerTwo := rrTwo.Header()
+	// Names beneath the empty non-terminal have a closest encloser of
+	// bar.disco.net. which has no wildcard
+	query = new(dns.Msg)
+	query.SetQuestion("foo.bar.disco.net.", dns.TypeA)
 
-	if headerTwo.Ttl != 600 {
-		t.Error("Expected TTL of 300 seconds:", headerTwo.Ttl)
+	answer = resolver.Lookup(query)
+
+	if len(answer.Answer) > 0 {
+		t.Error("Didn't expect any answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
-	if rrTwo.A.String() != "8.8.8.8" {
-		t.Error("Expected A record to be 8.8.8.8: ", rrTwo.A)
+
+	if answer.Rcode != dns.RcodeNameError {
+		t.Error("Expected NXDOMAIN response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
 }
 
-func TestAnswerQuestionTTLInvalidFormat(t *testing.T) {
-	resolver.etcdPrefix = "TestAnswerQuestionTTL/"
-	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A", "1.2.3.4", 0)
-	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A.ttl", "haha", 0)
+func TestAnswerQuestionWildcardNoData(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionWildcardNoData/"
+	client.Set("TestAnswerQuestionWildcardNoData/net/disco/bar/*/.TXT", "foo", 0)
+	client.Set("TestAnswerQuestionWildcardNoData/net/disco/*/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionWildcardNoData/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
-
-	if len(records) != 1 {
-		t.Error("Expected one answer, got ", len(records))
-		t.Fatal()
-	}
+	// The wildcard at the closest encloser exists but has no A records, we
+	// shouldn't keep looking further up the tree
+	query := new(dns.Msg)
+	query.SetQuestion("baz.bar.disco.net.", dns.TypeA)
 
-	rr := records[0].(*dns.A)
-	header := rr.Header()
+	answer := resolver.Lookup(query)
 
-	if header.Name != "bar.disco.net." {
-		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+	if len(answer.Answer) > 0 {
+		t.Error("Didn't expect any answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
-	if header.Rrtype != dns.TypeA {
-		t.Error("Expected record with type A:", header.Rrtype)
+
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
-	if header.Ttl != 0 {
-		t.Error("Expected TTL of 0 seconds:", header.Ttl)
+
+	query = new(dns.Msg)
+	query.SetQuestion("baz.bar.disco.net.", dns.TypeTXT)
+
+	answer = resolver.Lookup(query)
+
+	if len(answer.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(answer.Answer))
 		t.Fatal()
 	}
-	if rr.A.String() != "1.2.3.4" {
-		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
+
+	if answer.Answer[0].Header().Name != "baz.bar.disco.net." {
+		t.Error("Expected record with name baz.bar.disco.net.: ", answer.Answer[0].Header().Name)
 		t.Fatal()
 	}
 }
 
-func TestAnswerQuestionTTLDanglingNode(t *testing.T) {
-	resolver.etcdPrefix = "TestAnswerQuestionTTLDanglingNode/"
-	client.Set("TestAnswerQuestionTTLDanglingNode/net/disco/bar/.TXT.ttl", "600", 0)
+func TestAnswerQuestionDNAME(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionDNAME/"
+	client.Set("TestAnswerQuestionDNAME/net/disco/old/.DNAME", "new.disco.net.", 0)
+	client.Set("TestAnswerQuestionDNAME/net/disco/new/foo/.A", "1.2.3.4", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+	query := new(dns.Msg)
+	query.SetQuestion("foo.old.disco.net.", dns.TypeA)
 
-	if len(records) != 0 {
-		t.Error("Expected no answer, got ", len(records))
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 3 {
+		t.Error("Expected three answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
-}
-
-func TestAnswerQuestionTTLDanglingDirNode(t *testing.T) {
-	resolver.etcdPrefix = "TestAnswerQuestionTTLDanglingDirNode/"
-	client.Set("TestAnswerQuestionTTLDanglingDirNode/net/disco/bar/.TXT/0.ttl", "600", 0)
-	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
-
-	if len(records) != 0 {
-		t.Error("Expected no answer, got ", len(records))
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
-}
-
-func TestAnswerQuestionTTLDanglingDirSibling(t *testing.T) {
-	resolver.etcdPrefix = "TestAnswerQuestionTTLDanglingDirSibling/"
-	client.Set("TestAnswerQuestionTTLDanglingDirSibling/net/disco/bar/.TXT/0.ttl", "100", 0)
-	client.Set("TestAnswerQuestionTTLDanglingDirSibling/net/disco/bar/.TXT/1", "foo bar", 0)
-	client.Set("TestAnswerQuestionTTLDanglingDirSibling/net/disco/bar/.TXT/1.ttl", "600", 0)
-	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
-
-	if len(records) != 1 {
-		t.Error("Expected one answer, got ", len(records))
+	dname := answer.Answer[0].(*dns.DNAME)
+	if dname.Header().Name != "old.disco.net." {
+		t.Error("Expected DNAME with name old.disco.net.: ", dname.Header().Name)
+		t.Fatal()
+	}
+	if dname.Target != "new.disco.net." {
+		t.Error("Expected DNAME target new.disco.net.: ", dname.Target)
 		t.Fatal()
 	}
 
-	rr := records[0].(*dns.TXT)
-	header := rr.Header()
-
-	if header.Name != "bar.disco.net." {
-		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+	cname := answer.Answer[1].(*dns.CNAME)
+	if cname.Header().Name != "foo.old.disco.net." {
+		t.Error("Expected CNAME with name foo.old.disco.net.: ", cname.Header().Name)
 		t.Fatal()
 	}
-	if header.Rrtype != dns.TypeTXT {
-		t.Error("Expected record with type TXT:", header.Rrtype)
+	if cname.Target != "foo.new.disco.net." {
+		t.Error("Expected CNAME target foo.new.disco.net.: ", cname.Target)
 		t.Fatal()
 	}
-	if header.Ttl != 600 {
-		t.Error("Expected TTL of 600 seconds:", header.Ttl)
+
+	rr := answer.Answer[2].(*dns.A)
+	if rr.Header().Name != "foo.new.disco.net." {
+		t.Error("Expected record with name foo.new.disco.net.: ", rr.Header().Name)
 		t.Fatal()
 	}
-	if strings.Join(rr.Txt, "\n") != "foo bar" {
-		t.Error("Expected txt record to be 'foo bar': ", rr.Txt)
+	if rr.A.String() != "1.2.3.4" {
+		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
 		t.Fatal()
 	}
 }
 
-/**
- * Test converstion of names (i.e etcd nodes) to single records of different
- * types.
- **/
-
-func TestLookupAnswerForA(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForA/"
-	client.Set("TestLookupAnswerForA/net/disco/bar/.A", "1.2.3.4", 0)
+func TestAnswerQuestionDNAMENXDomain(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionDNAMENXDomain/"
+	client.Set("TestAnswerQuestionDNAMENXDomain/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestAnswerQuestionDNAMENXDomain/net/disco/old/.DNAME", "new.disco.net.", 0)
+	client.Set("TestAnswerQuestionDNAMENXDomain/net/disco/new/foo/.A", "1.2.3.4", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+	// The rewritten name doesn't exist
+	query := new(dns.Msg)
+	query.SetQuestion("bar.old.disco.net.", dns.TypeA)
 
-	if len(records) != 1 {
-		t.Error("Expected one answer, got ", len(records))
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 2 {
+		t.Error("Expected two answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
 
-	rr := records[0].(*dns.A)
-	header := rr.Header()
-
-	if header.Name != "bar.disco.net." {
-		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+	if answer.Rcode != dns.RcodeNameError {
+		t.Error("Expected NXDOMAIN response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
-	if header.Rrtype != dns.TypeA {
-		t.Error("Expected record with type A:", header.Rrtype)
+
+	if len(answer.Ns) != 1 {
+		t.Error("Expected one authority record, got ", len(answer.Ns))
 		t.Fatal()
 	}
-	if rr.A.String() != "1.2.3.4" {
-		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
+
+	if _, ok := answer.Ns[0].(*dns.SOA); !ok {
+		t.Error("Expected SOA authority record, got ", answer.Ns[0])
 		t.Fatal()
 	}
-}
 
-func TestLookupAnswerForAAAA(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForAAAA/"
-	client.Set("TestLookupAnswerForAAAA/net/disco/bar/.AAAA", "::1", 0)
-	defer client.Delete(resolver.etcdPrefix, true)
+	// The rewritten name exists, but has no records of the type
+	query = new(dns.Msg)
+	query.SetQuestion("foo.old.disco.net.", dns.TypeAAAA)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeAAAA)
+	answer = resolver.Lookup(query)
 
-	if len(records) != 1 {
-		t.Error("Expected one answer, got ", len(records))
+	if len(answer.Answer) != 2 {
+		t.Error("Expected two answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
 
-	rr := records[0].(*dns.AAAA)
-	header := rr.Header()
-
-	if header.Name != "bar.disco.net." {
-		t.Error("Expected record with name bar.disco.net.: ", header.Name)
-		t.Fatal()
-	}
-	if header.Rrtype != dns.TypeAAAA {
-		t.Error("Expected record with type AAAA:", header.Rrtype)
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
-	if rr.AAAA.String() != "::1" {
-		t.Error("Expected AAAA record to be ::1: ", rr.AAAA)
+
+	if len(answer.Ns) != 1 {
+		t.Error("Expected one authority record, got ", len(answer.Ns))
 		t.Fatal()
 	}
 }
 
-func TestLookupAnswerForCNAME(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForCNAME/"
-	client.Set("TestLookupAnswerForCNAME/net/disco/bar/.CNAME", "cname.google.com.", 0)
+func TestAnswerQuestionDNAMEOwner(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionDNAMEOwner/"
+	client.Set("TestAnswerQuestionDNAMEOwner/net/disco/old/.DNAME", "new.disco.net.", 0)
+	client.Set("TestAnswerQuestionDNAMEOwner/net/disco/old/.A", "1.2.3.4", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeCNAME)
+	// The DNAME doesn't apply to its owner
+	query := new(dns.Msg)
+	query.SetQuestion("old.disco.net.", dns.TypeA)
 
-	if len(records) != 1 {
-		t.Error("Expected one answer, got ", len(records))
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(answer.Answer))
 		t.Fatal()
 	}
 
-	rr := records[0].(*dns.CNAME)
-	header := rr.Header()
-
-	if header.Name != "bar.disco.net." {
-		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+	rr := answer.Answer[0].(*dns.A)
+	if rr.A.String() != "1.2.3.4" {
+		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
 		t.Fatal()
 	}
-	if header.Rrtype != dns.TypeCNAME {
-		t.Error("Expected record with type CNAME:", header.Rrtype)
+}
+
+func TestAnswerQuestionDNAMELoop(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionDNAMELoop/"
+	client.Set("TestAnswerQuestionDNAMELoop/net/disco/a/.DNAME", "b.disco.net.", 0)
+	client.Set("TestAnswerQuestionDNAMELoop/net/disco/b/.DNAME", "a.disco.net.", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	query := new(dns.Msg)
+	query.SetQuestion("foo.a.disco.net.", dns.TypeA)
+
+	answer := resolver.Lookup(query)
+
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
 		t.Fatal()
 	}
-	if rr.Target != "cname.google.com." {
-		t.Error("Expected CNAME record to be cname.google.com.: ", rr.Target)
+
+	if len(answer.Answer) != (maxChainDepth+1)*2 {
+		t.Error("Expected DNAME chain to be cut short, got ", len(answer.Answer))
 		t.Fatal()
 	}
 }
 
-func TestLookupAnswerForNS(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForNS/"
-	client.Set("TestLookupAnswerForNS/net/disco/bar/.NS", "dns.google.com.", 0)
+func TestAnswerQuestionALIAS(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionALIAS/"
+	client.Set("TestAnswerQuestionALIAS/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestAnswerQuestionALIAS/net/disco/.ALIAS", "lb.disco.net.", 0)
+	client.Set("TestAnswerQuestionALIAS/net/disco/.ALIAS.ttl", "60", 0)
+	client.Set("TestAnswerQuestionALIAS/net/disco/lb/.A/0", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionALIAS/net/disco/lb/.A/0.ttl", "300", 0)
+	client.Set("TestAnswerQuestionALIAS/net/disco/lb/.A/1", "2.3.4.5", 0)
+	client.Set("TestAnswerQuestionALIAS/net/disco/lb/.A/1.ttl", "30", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	query := new(dns.Msg)
+	query.SetQuestion("disco.net.", dns.TypeA)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 2 {
+		t.Error("Expected two answers, got ", len(answer.Answer))
+		t.Fatal()
+	}
+
+	for i, expectedTtl := range []uint32{60, 30} {
+		rr := answer.Answer[i].(*dns.A)
+		header := rr.Header()
+
+		if header.Name != "disco.net." {
+			t.Error("Expected record with name disco.net.: ", header.Name)
+			t.Fatal()
+		}
+		if header.Ttl != expectedTtl {
+			t.Error("Expected TTL of", expectedTtl, "seconds:", header.Ttl)
+			t.Fatal()
+		}
+	}
+
+	// There are no AAAA records for the target
+	query = new(dns.Msg)
+	query.SetQuestion("disco.net.", dns.TypeAAAA)
+
+	answer = resolver.Lookup(query)
+
+	if len(answer.Answer) > 0 {
+		t.Error("Didn't expect any answers, got ", len(answer.Answer))
+		t.Fatal()
+	}
+
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionALIASChain(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionALIASChain/"
+	client.Set("TestAnswerQuestionALIASChain/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/.ALIAS", "lb.disco.net.", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/.ALIAS.ttl", "60", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/real/.ALIAS.ttl", "60", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/lb/.CNAME", "real.disco.net.", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/lb/.CNAME.ttl", "30", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/real/.ALIAS", "target.disco.net.", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/target/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/target/.A.ttl", "300", 0)
+	client.Set("TestAnswerQuestionALIASChain/net/disco/loop/.ALIAS", "loop.disco.net.", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	query := new(dns.Msg)
+	query.SetQuestion("disco.net.", dns.TypeA)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(answer.Answer))
+		t.Fatal()
+	}
+
+	rr := answer.Answer[0].(*dns.A)
+	if rr.Header().Name != "disco.net." || rr.A.String() != "1.2.3.4" {
+		t.Error("Unexpected answer:", rr)
+		t.Fatal()
+	}
+	if rr.Header().Ttl != 30 {
+		t.Error("Expected TTL of 30 seconds from the CNAME:", rr.Header().Ttl)
+		t.Fatal()
+	}
+
+	// ALIAS records that lead back to themselves aren't followed forever
+	query = new(dns.Msg)
+	query.SetQuestion("loop.disco.net.", dns.TypeA)
+
+	answer = resolver.Lookup(query)
+
+	if answer.Rcode != dns.RcodeServerFailure {
+		t.Error("Expected SERVFAIL response code, got", dns.RcodeToString[answer.Rcode])
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionALIASUpstream(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionALIASUpstream/"
+	client.Set("TestAnswerQuestionALIASUpstream/net/disco/.ALIAS", "lb.example.com.", 0)
+	client.Set("TestAnswerQuestionALIASUpstream/net/disco/.ALIAS.ttl", "600", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	upstream := &dns.Server{Addr: "127.0.0.1:10053", Net: "udp"}
+	upstream.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		if len(req.Question) == 0 {
+			w.WriteMsg(msg)
+			return
+		}
+
+		msg.SetReply(req)
+		rr, _ := dns.NewRR(req.Question[0].Name + " 120 IN A 10.0.0.1")
+		msg.Answer = []dns.RR{rr}
+		w.WriteMsg(msg)
+	})
+
+	started := make(chan bool)
+	upstream.NotifyStartedFunc = func() { started <- true }
+	go upstream.ListenAndServe()
+	defer upstream.Shutdown()
+	<-started
+
+	resolver.aliasUpstream = upstream.Addr
+	defer func() { resolver.aliasUpstream = "" }()
+
+	query := new(dns.Msg)
+	query.SetQuestion("disco.net.", dns.TypeA)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(answer.Answer))
+		t.Fatal()
+	}
+
+	rr := answer.Answer[0].(*dns.A)
+	header := rr.Header()
+
+	if header.Name != "disco.net." {
+		t.Error("Expected record with name disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Ttl != 120 {
+		t.Error("Expected TTL of 120 seconds:", header.Ttl)
+		t.Fatal()
+	}
+	if rr.A.String() != "10.0.0.1" {
+		t.Error("Expected A record to be 10.0.0.1: ", rr.A)
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTL(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTL/"
+	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A.ttl", "300", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.A)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeA {
+		t.Error("Expected record with type A:", header.Rrtype)
+		t.Fatal()
+	}
+	if header.Ttl != 300 {
+		t.Error("Expected TTL of 300 seconds:", header.Ttl)
+		t.Fatal()
+	}
+	if rr.A.String() != "1.2.3.4" {
+		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTLMultipleRecords(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLMultipleRecords/"
+	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/0", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/0.ttl", "300", 0)
+	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/1", "8.8.8.8", 0)
+	client.Set("TestAnswerQuestionTTLMultipleRecords/net/disco/bar/.A/1.ttl", "600", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	rrOne := records[0].(*dns.A)
+	headerOne := rrOne.Header()
+
+	if headerOne.Ttl != 300 {
+		t.Error("Expected TTL of 300 seconds:", headerOne.Ttl)
+		t.Fatal()
+	}
+	if rrOne.A.String() != "1.2.3.4" {
+		t.Error("Expected A record to be 1.2.3.4: ", rrOne.A)
+		t.Fatal()
+	}
+
+	rrTwo := records[1].(*dns.A)
+	headerTwo := rrTwo.Header()
+
+	if headerTwo.Ttl != 600 {
+		t.Error("Expected TTL of 300 seconds:", headerTwo.Ttl)
+		t.Fatal()
+	}
+	if rrTwo.A.String() != "8.8.8.8" {
+		t.Error("Expected A record to be 8.8.8.8: ", rrTwo.A)
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTLInvalidFormat(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTL/"
+	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionTTL/net/disco/bar/.A.ttl", "haha", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.A)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeA {
+		t.Error("Expected record with type A:", header.Rrtype)
+		t.Fatal()
+	}
+	if header.Ttl != 0 {
+		t.Error("Expected TTL of 0 seconds:", header.Ttl)
+		t.Fatal()
+	}
+	if rr.A.String() != "1.2.3.4" {
+		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTLDanglingNode(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLDanglingNode/"
+	client.Set("TestAnswerQuestionTTLDanglingNode/net/disco/bar/.TXT.ttl", "600", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+
+	if len(records) != 0 {
+		t.Error("Expected no answer, got ", len(records))
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTLDanglingDirNode(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLDanglingDirNode/"
+	client.Set("TestAnswerQuestionTTLDanglingDirNode/net/disco/bar/.TXT/0.ttl", "600", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+
+	if len(records) != 0 {
+		t.Error("Expected no answer, got ", len(records))
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTLDanglingDirSibling(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLDanglingDirSibling/"
+	client.Set("TestAnswerQuestionTTLDanglingDirSibling/net/disco/bar/.TXT/0.ttl", "100", 0)
+	client.Set("TestAnswerQuestionTTLDanglingDirSibling/net/disco/bar/.TXT/1", "foo bar", 0)
+	client.Set("TestAnswerQuestionTTLDanglingDirSibling/net/disco/bar/.TXT/1.ttl", "600", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.TXT)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeTXT {
+		t.Error("Expected record with type TXT:", header.Rrtype)
+		t.Fatal()
+	}
+	if header.Ttl != 600 {
+		t.Error("Expected TTL of 600 seconds:", header.Ttl)
+		t.Fatal()
+	}
+	if strings.Join(rr.Txt, "\n") != "foo bar" {
+		t.Error("Expected txt record to be 'foo bar': ", rr.Txt)
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTLInherited(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLInherited/"
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/.ttl", "600", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/.ttl.TXT", "60", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/baz/.ttl", "900", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/bar/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/bar/.AAAA", "::1", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/bar/.AAAA.ttl", "100", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/bar/.TXT", "foo", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/disco/baz/.TXT", "foo", 0)
+	client.Set("TestAnswerQuestionTTLInherited/net/other/.A", "1.2.3.4", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var expected = []struct {
+		name   string
+		rrType uint16
+		ttl    uint32
+	}{
+		{"bar.disco.net.", dns.TypeA, 600},             // Inherited from the zone
+		{"bar.disco.net.", dns.TypeAAAA, 100},          // The record's own TTL
+		{"bar.disco.net.", dns.TypeTXT, 60},            // Inherited for the type
+		{"baz.disco.net.", dns.TypeTXT, 900},           // Closest default wins
+		{"other.net.", dns.TypeA, resolver.defaultTtl}} // Global default
+
+	for _, e := range expected {
+		records, _ := resolver.LookupAnswersForType(e.name, e.rrType)
+
+		if len(records) != 1 {
+			t.Error("Expected one answer, got ", len(records))
+			t.Fatal()
+		}
+
+		if records[0].Header().Ttl != e.ttl {
+			t.Error("Expected TTL of", e.ttl, "seconds for", e.name, dns.TypeToString[e.rrType], "got", records[0].Header().Ttl)
+			t.Fatal()
+		}
+	}
+}
+
+func TestAnswerQuestionTTLClamped(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLClamped/"
+	resolver.minTtl = 10
+	resolver.maxTtl = 86400
+	client.Set("TestAnswerQuestionTTLClamped/net/disco/bar/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/disco/bar/.A.ttl", "8640000", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/disco/bar/.AAAA", "::1", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/disco/bar/.AAAA.ttl", "0", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/disco/bar/.TXT", "foo", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/disco/bar/.TXT.ttl", "300", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/zone/.minttl", "60", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/zone/.maxttl", "3600", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/zone/bar/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/zone/bar/.A.ttl", "86400", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/zone/bar/.AAAA", "::1", 0)
+	client.Set("TestAnswerQuestionTTLClamped/net/zone/bar/.AAAA.ttl", "10", 0)
+	defer func() {
+		resolver.minTtl = 0
+		resolver.maxTtl = 0
+	}()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var expected = []struct {
+		name   string
+		rrType uint16
+		ttl    uint32
+	}{
+		{"bar.disco.net.", dns.TypeA, 86400}, // Global maximum
+		{"bar.disco.net.", dns.TypeAAAA, 10}, // Global minimum
+		{"bar.disco.net.", dns.TypeTXT, 300}, // Within bounds
+		{"bar.zone.net.", dns.TypeA, 3600},   // Zone maximum
+		{"bar.zone.net.", dns.TypeAAAA, 60}}  // Zone minimum
+
+	for _, e := range expected {
+		records, _ := resolver.LookupAnswersForType(e.name, e.rrType)
+
+		if len(records) != 1 {
+			t.Error("Expected one answer, got ", len(records))
+			t.Fatal()
+		}
+
+		if records[0].Header().Ttl != e.ttl {
+			t.Error("Expected TTL of", e.ttl, "seconds for", e.name, dns.TypeToString[e.rrType], "got", records[0].Header().Ttl)
+			t.Fatal()
+		}
+	}
+}
+
+func TestAnswerQuestionTTLExpiringKey(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLExpiringKey/"
+	client.Set("TestAnswerQuestionTTLExpiringKey/net/disco/bar/.A/0", "1.2.3.4", 30)
+	client.Set("TestAnswerQuestionTTLExpiringKey/net/disco/bar/.A/0.ttl", "3600", 0)
+	client.Set("TestAnswerQuestionTTLExpiringKey/net/disco/bar/.A/1", "2.3.4.5", 3600)
+	client.Set("TestAnswerQuestionTTLExpiringKey/net/disco/bar/.A/1.ttl", "60", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	if ttl := records[0].Header().Ttl; ttl == 0 || ttl > 30 {
+		t.Error("Expected TTL of at most 30 seconds:", ttl)
+		t.Fatal()
+	}
+	if ttl := records[1].Header().Ttl; ttl != 60 {
+		t.Error("Expected TTL of 60 seconds:", ttl)
+		t.Fatal()
+	}
+}
+
+func TestAnswerQuestionTTLCachedSettings(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionTTLCachedSettings/"
+	resolver.settingsCache = newSettingsCache(time.Minute)
+	client.Set("TestAnswerQuestionTTLCachedSettings/net/disco/.ttl", "60", 0)
+	client.Set("TestAnswerQuestionTTLCachedSettings/net/disco/bar/.A", "1.2.3.4", 0)
+	defer func() { resolver.settingsCache = nil }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	ttl := func() uint32 {
+		records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+		if len(records) != 1 {
+			t.Error("Expected one answer, got ", len(records))
+			t.Fatal()
+		}
+		return records[0].Header().Ttl
+	}
+
+	if ttl() != 60 {
+		t.Error("Expected TTL of 60 seconds")
+		t.Fatal()
+	}
+
+	// Settings aren't read again until the cache expires
+	client.Set("TestAnswerQuestionTTLCachedSettings/net/disco/.ttl", "120", 0)
+	client.Set("TestAnswerQuestionTTLCachedSettings/net/disco/bar/.ttl", "30", 0)
+
+	if ttl() != 60 {
+		t.Error("Expected TTL of 60 seconds from the cached settings")
+		t.Fatal()
+	}
+
+	resolver.settingsCache = newSettingsCache(time.Minute)
+	if ttl() != 30 {
+		t.Error("Expected TTL of 30 seconds")
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerOrder(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerOrder/"
+	client.Set("TestLookupAnswerOrder/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestLookupAnswerOrder/net/disco/.order", "rotate", 0)
+	client.Set("TestLookupAnswerOrder/net/disco/stable/.order.A", "stable", 0)
+	client.Set("TestLookupAnswerOrder/net/disco/shuffle/.order", "shuffle", 0)
+	for _, name := range []string{"bar", "stable", "shuffle"} {
+		client.Set("TestLookupAnswerOrder/net/disco/"+name+"/.A/0", "1.1.1.1", 0)
+		client.Set("TestLookupAnswerOrder/net/disco/"+name+"/.A/1", "2.2.2.2", 0)
+		client.Set("TestLookupAnswerOrder/net/disco/"+name+"/.A/2", "3.3.3.3", 0)
+	}
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	lookup := func(name string) (order string) {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+
+		answer := resolver.Lookup(query)
+		if len(answer.Answer) != 3 {
+			t.Error("Expected three answers, got ", len(answer.Answer))
+			t.Fatal()
+		}
+
+		for _, rr := range answer.Answer {
+			order += rr.(*dns.A).A.String()[:1]
+		}
+		return
+	}
+
+	// Rotated, inherited from the zone
+	first := lookup("bar.disco.net.")
+	second := lookup("bar.disco.net.")
+	if first[1:]+first[:1] != second {
+		t.Error("Expected answers to be rotated by one:", first, second)
+		t.Fatal()
+	}
+
+	// Stable, for the type
+	for i := 0; i < 5; i++ {
+		if order := lookup("stable.disco.net."); order != "123" {
+			t.Error("Expected answers in stored order:", order)
+			t.Fatal()
+		}
+	}
+
+	// Shuffled, it's very unlikely to come out the same order 20 times
+	orders := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		orders[lookup("shuffle.disco.net.")] = true
+	}
+	if len(orders) < 2 {
+		t.Error("Expected answers to be shuffled:", orders)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerRotateInterleaved(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerRotateInterleaved/"
+	client.Set("TestLookupAnswerRotateInterleaved/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestLookupAnswerRotateInterleaved/net/disco/.order", "rotate", 0)
+	for _, name := range []string{"a", "b"} {
+		client.Set("TestLookupAnswerRotateInterleaved/net/disco/"+name+"/.A/0", "1.1.1.1", 0)
+		client.Set("TestLookupAnswerRotateInterleaved/net/disco/"+name+"/.A/1", "2.2.2.2", 0)
+	}
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	first := func(name string) string {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+
+		answer := resolver.Lookup(query)
+		if len(answer.Answer) != 2 {
+			t.Error("Expected two answers, got ", len(answer.Answer))
+			t.Fatal()
+		}
+
+		return answer.Answer[0].(*dns.A).A.String()
+	}
+
+	// Each name rotates on its own, however queries for them are interleaved
+	firsts := map[string]map[string]bool{"a": {}, "b": {}}
+	for i := 0; i < 4; i++ {
+		firsts["a"][first("a.disco.net.")] = true
+		firsts["b"][first("b.disco.net.")] = true
+	}
+
+	if len(firsts["a"]) != 2 || len(firsts["b"]) != 2 {
+		t.Error("Expected both names to rotate:", firsts)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerMaxAnswers(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerMaxAnswers/"
+	client.Set("TestLookupAnswerMaxAnswers/net/disco/.maxanswers", "2", 0)
+	client.Set("TestLookupAnswerMaxAnswers/net/disco/.maxanswers.MX", "1", 0)
+	for i := 1; i <= 3; i++ {
+		n := strconv.Itoa(i)
+		client.Set("TestLookupAnswerMaxAnswers/net/disco/.A/"+n, n+"."+n+"."+n+"."+n, 0)
+		client.Set("TestLookupAnswerMaxAnswers/net/disco/.NS/"+n, "ns"+n+".disco.net.", 0)
+		client.Set("TestLookupAnswerMaxAnswers/net/disco/.MX/"+n, n+"\tmx"+n+".disco.net.", 0)
+	}
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	// Without weights, a stable order returns the first records every time
+	for i := 0; i < 5; i++ {
+		records, _ := resolver.LookupAnswersForType("disco.net.", dns.TypeA)
+
+		if len(records) != 2 {
+			t.Error("Expected two answers, got ", len(records))
+			t.Fatal()
+		}
+		if records[0].(*dns.A).A.String() != "1.1.1.1" || records[1].(*dns.A).A.String() != "2.2.2.2" {
+			t.Error("Expected the first two records in stored order:", records)
+			t.Fatal()
+		}
+	}
+
+	// Other types are only limited by a limit for their type
+	records, _ := resolver.LookupAnswersForType("disco.net.", dns.TypeNS)
+	if len(records) != 3 {
+		t.Error("Expected three NS answers, got ", len(records))
+		t.Fatal()
+	}
+
+	records, _ = resolver.LookupAnswersForType("disco.net.", dns.TypeMX)
+	if len(records) != 1 {
+		t.Error("Expected one MX answer, got ", len(records))
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerWeighted(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerWeighted/"
+	resolver.random = rand.New(rand.NewSource(1))
+	client.Set("TestLookupAnswerWeighted/net/disco/bar/.maxanswers", "1", 0)
+	client.Set("TestLookupAnswerWeighted/net/disco/bar/.A/0", "1.1.1.1", 0)
+	client.Set("TestLookupAnswerWeighted/net/disco/bar/.A/0.weight", "0", 0)
+	client.Set("TestLookupAnswerWeighted/net/disco/bar/.A/1", "2.2.2.2", 0)
+	client.Set("TestLookupAnswerWeighted/net/disco/bar/.A/1.ttl", "60", 0)
+	client.Set("TestLookupAnswerWeighted/net/disco/bar/.A/1.weight", "1", 0)
+	client.Set("TestLookupAnswerWeighted/net/disco/bar/.A/2", `{"host": "3.3.3.3", "weight": 3}`, 0)
+	defer func() { resolver.random = nil }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	picked := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+		if len(records) != 1 {
+			t.Error("Expected one answer, got ", len(records))
+			t.Fatal()
+		}
+
+		rr := records[0].(*dns.A)
+		if rr.A.String() == "2.2.2.2" && rr.Header().Ttl != 60 {
+			t.Error("Expected TTL of 60 seconds:", rr.Header().Ttl)
+			t.Fatal()
+		}
+
+		picked[rr.A.String()]++
+	}
+
+	if picked["1.1.1.1"] != 0 {
+		t.Error("Didn't expect a record with no weight to be picked:", picked)
+		t.Fatal()
+	}
+	if picked["3.3.3.3"] <= picked["2.2.2.2"] || picked["2.2.2.2"] == 0 {
+		t.Error("Expected records to be picked in proportion to their weight:", picked)
+		t.Fatal()
+	}
+
+	// Without a maximum, every record with a weight is returned
+	client.Delete("TestLookupAnswerWeighted/net/disco/bar/.maxanswers", false)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerClientHash(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerClientHash/"
+	client.Set("TestLookupAnswerClientHash/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestLookupAnswerClientHash/net/disco/bar/.order", "hash", 0)
+	client.Set("TestLookupAnswerClientHash/net/disco/bar/.maxanswers", "1", 0)
+	for i := 0; i < 5; i++ {
+		client.Set("TestLookupAnswerClientHash/net/disco/bar/.A/"+strconv.Itoa(i), "10.0.0."+strconv.Itoa(i), 0)
+	}
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	lookup := func(clientIP string) string {
+		query := new(dns.Msg)
+		query.SetQuestion("bar.disco.net.", dns.TypeA)
+
+		answer := resolver.LookupForClient(query, net.ParseIP(clientIP))
+		if len(answer.Answer) != 1 {
+			t.Error("Expected one answer, got ", len(answer.Answer))
+			t.Fatal()
+		}
+
+		return answer.Answer[0].(*dns.A).A.String()
+	}
+
+	// Each client consistently gets the same answer
+	before := make(map[string]string)
+	picked := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		clientIP := "192.168.0." + strconv.Itoa(i)
+		before[clientIP] = lookup(clientIP)
+		picked[before[clientIP]] = true
+
+		if lookup(clientIP) != before[clientIP] {
+			t.Error("Expected the same answer for client", clientIP)
+			t.Fatal()
+		}
+	}
+
+	if len(picked) < 3 {
+		t.Error("Expected clients to be spread across the answers:", picked)
+		t.Fatal()
+	}
+
+	// Removing a record only moves the clients that had it
+	client.Delete("TestLookupAnswerClientHash/net/disco/bar/.A/0", false)
+
+	for clientIP, answer := range before {
+		if answer != "10.0.0.0" && lookup(clientIP) != answer {
+			t.Error("Expected client", clientIP, "to keep answer", answer)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerFailover(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerFailover/"
+	resolver.health = NewHealthChecker(client, "", time.Minute, time.Second)
+	client.Set("TestLookupAnswerFailover/net/disco/bar/.A/0", "1.1.1.1", 0)
+	client.Set("TestLookupAnswerFailover/net/disco/bar/.A/0.check", "etcd:///TestLookupAnswerFailover/health/0", 0)
+	client.Set("TestLookupAnswerFailover/net/disco/bar/.A/.failover/1/0", "2.2.2.2", 0)
+	client.Set("TestLookupAnswerFailover/net/disco/bar/.A/.failover/1/0.check", "etcd:///TestLookupAnswerFailover/health/1", 0)
+	client.Set("TestLookupAnswerFailover/net/disco/bar/.A/.failover/2/0", "3.3.3.3", 0)
+	client.Set("TestLookupAnswerFailover/net/disco/bar/.A/.failover/invalid/0", "4.4.4.4", 0)
+	client.Set("TestLookupAnswerFailover/net/disco/baz/.A/.failover/1/0", "5.5.5.5", 0)
+	client.Set("TestLookupAnswerFailover/health/0", "ok", 0)
+	client.Set("TestLookupAnswerFailover/health/1", "ok", 0)
+	defer func() { resolver.health = nil }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	expect := func(name string, address string) {
+		records, err := resolver.LookupAnswersForType(name, dns.TypeA)
+		if err != nil {
+			t.Error("Unexpected error: ", err)
+			t.Fatal()
+		}
+
+		if len(records) != 1 {
+			t.Error("Expected one answer, got ", len(records))
+			t.Fatal()
+		}
+
+		if rr := records[0].(*dns.A); rr.A.String() != address {
+			t.Error("Expected " + address + ", got " + rr.A.String())
+			t.Fatal()
+		}
+	}
+
+	// Only the primary tier is returned while it's healthy
+	expect("bar.disco.net.", "1.1.1.1")
+	resolver.health.CheckAll()
+	expect("bar.disco.net.", "1.1.1.1")
+
+	client.Delete("TestLookupAnswerFailover/health/0", false)
+	resolver.health.CheckAll()
+	expect("bar.disco.net.", "2.2.2.2")
+
+	client.Delete("TestLookupAnswerFailover/health/1", false)
+	resolver.health.CheckAll()
+	expect("bar.disco.net.", "3.3.3.3")
+
+	// Backup records are returned when there are no primary records
+	expect("baz.disco.net.", "5.5.5.5")
+}
+
+func TestLookupGeoAnswers(t *testing.T) {
+	path := writeTestGeoIP(t, 6, 32, map[string]interface{}{
+		"10.1.0.0/16": map[string]interface{}{
+			"continent":    map[string]interface{}{"code": "NA"},
+			"country":      map[string]interface{}{"iso_code": "US"},
+			"subdivisions": []interface{}{map[string]interface{}{"iso_code": "CA"}}},
+		"10.2.0.0/16": map[string]interface{}{
+			"continent": map[string]interface{}{"code": "EU"},
+			"country":   map[string]interface{}{"iso_code": "GB"}}})
+	defer os.Remove(path)
+
+	geo, err := NewGeoIP(path)
+	if err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+
+	resolver.etcdPrefix = "TestLookupGeoAnswers/"
+	resolver.geo = geo
+	client.Set("TestLookupGeoAnswers/net/disco/bar/.A", "1.1.1.1", 0)
+	client.Set("TestLookupGeoAnswers/net/disco/bar/.geo/us-ca/.A", "2.2.2.2", 0)
+	client.Set("TestLookupGeoAnswers/net/disco/bar/.geo/us/.A", "3.3.3.3", 0)
+	client.Set("TestLookupGeoAnswers/net/disco/bar/.geo/eu/.A/0", "4.4.4.4", 0)
+	client.Set("TestLookupGeoAnswers/net/disco/bar/.geo/eu/.A/1", "5.5.5.5", 0)
+	client.Set("TestLookupGeoAnswers/net/disco/bar/.geo/eu/.AAAA", "::1", 0)
+	defer func() { resolver.geo = nil }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	expect := func(address string, rrType uint16, expected ...string) {
+		query := new(dns.Msg)
+		query.SetQuestion("bar.disco.net.", rrType)
+
+		var clientIP net.IP
+		if len(address) > 0 {
+			clientIP = net.ParseIP(address)
+		}
+
+		msg := resolver.LookupForClient(query, clientIP)
+		if len(msg.Answer) != len(expected) {
+			t.Error("Expected ", len(expected), " answers for "+address+", got ", len(msg.Answer))
+			t.Fatal()
+		}
+
+		for i, rr := range msg.Answer {
+			var value string
+			switch rr := rr.(type) {
+			case *dns.A:
+				value = rr.A.String()
+			case *dns.AAAA:
+				value = rr.AAAA.String()
+			}
+
+			if value != expected[i] {
+				t.Error("Expected " + expected[i] + " for " + address + ", got " + value)
+				t.Fatal()
+			}
+		}
+	}
+
+	// The closest location with records is used, otherwise the records for
+	// everyone else
+	expect("10.1.2.3", dns.TypeA, "2.2.2.2")
+	expect("10.2.2.3", dns.TypeA, "4.4.4.4", "5.5.5.5")
+	expect("10.2.2.3", dns.TypeAAAA, "::1")
+	expect("10.3.2.3", dns.TypeA, "1.1.1.1")
+	expect("2001:db8::1", dns.TypeA, "1.1.1.1")
+	expect("", dns.TypeA, "1.1.1.1")
+
+	client.Delete("TestLookupGeoAnswers/net/disco/bar/.geo/us-ca/.A", false)
+	expect("10.1.2.3", dns.TypeA, "3.3.3.3")
+
+	// The database is reloaded when the file changes
+	updated := writeTestGeoIP(t, 4, 24, map[string]interface{}{
+		"10.3.0.0/16": map[string]interface{}{
+			"continent": map[string]interface{}{"code": "EU"},
+			"country":   map[string]interface{}{"iso_code": "FR"}}})
+	defer os.Remove(updated)
+
+	if err := os.Rename(updated, path); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if err := geo.Reload(); err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+
+	expect("10.1.2.3", dns.TypeA, "1.1.1.1")
+	expect("10.3.2.3", dns.TypeA, "4.4.4.4", "5.5.5.5")
+
+	// An invalid file doesn't replace the loaded database
+	ioutil.WriteFile(path, []byte("not a database"), 0644)
+	earlier := time.Now().Add(-time.Minute)
+	os.Chtimes(path, earlier, earlier)
+
+	if err := geo.Reload(); err == nil {
+		t.Error("Expected an error reloading an invalid database")
+		t.Fatal()
+	}
+
+	expect("10.3.2.3", dns.TypeA, "4.4.4.4", "5.5.5.5")
+}
+
+func TestDecodeMMDBInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"self pointer":           {0x20, 0x00},
+		"pointer to pointer":     {0x20, 0x02, 0x20, 0x00},
+		"map pointing to itself": {0xE1, 0x41, 'a', 0x20, 0x00},
+		"huge map":               {0xFF, 0xFF, 0xFF, 0xFF},
+		"huge array":             {0x1F, 0x04, 0xFF, 0xFF, 0xFF}} {
+		if _, _, err := decodeMMDB(data, 0); err == nil {
+			t.Error("Expected an error decoding " + name)
+			t.Fatal()
+		}
+	}
+}
+
+func TestSelectAnswers(t *testing.T) {
+	answers := []dns.RR{
+		&dns.A{A: net.ParseIP("1.1.1.1")},
+		&dns.A{A: net.ParseIP("2.2.2.2")},
+		&dns.A{A: net.ParseIP("3.3.3.3")}}
+
+	// The same source of randomness picks the same answers
+	first := &Resolver{random: rand.New(rand.NewSource(42))}
+	second := &Resolver{random: rand.New(rand.NewSource(42))}
+	for i := 0; i < 10; i++ {
+		a := first.SelectAnswers(answers, []uint32{1, 2, 3}, 2)
+		b := second.SelectAnswers(answers, []uint32{1, 2, 3}, 2)
+
+		if len(a) != 2 || a[0] != b[0] || a[1] != b[1] {
+			t.Error("Expected the same answers to be picked:", a, b)
+			t.Fatal()
+		}
+	}
+
+	// Answers are still picked when they all have no weight
+	selected := first.SelectAnswers(answers, []uint32{0, 0, 0}, 0)
+	if len(selected) != 3 {
+		t.Error("Expected three answers, got ", len(selected))
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerHealthChecks(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	healthy := "tcp://" + listener.Addr().String()
+	unhealthy := "tcp://" + closed.Addr().String()
+
+	resolver.etcdPrefix = "TestLookupAnswerHealthChecks/"
+	resolver.health = NewHealthChecker(client, "", time.Minute, time.Second)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/bar/.A/0", "1.1.1.1", 0)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/bar/.A/0.check", healthy, 0)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/bar/.A/1", "2.2.2.2", 0)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/bar/.A/1.check", unhealthy, 0)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/bar/.A/2", `{"host": "3.3.3.3", "check": "etcd:///TestLookupAnswerHealthChecks/health/3"}`, 0)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/bar/.A/3", "4.4.4.4", 0)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/baz/.A/0", "5.5.5.5", 0)
+	client.Set("TestLookupAnswerHealthChecks/net/disco/baz/.A/0.check", unhealthy, 0)
+	client.Set("TestLookupAnswerHealthChecks/health/3", "ok", 0)
+	defer func() { resolver.health = nil }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	// Records are healthy until their checks have been probed
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+	if len(records) != 4 {
+		t.Error("Expected four answers before probing, got ", len(records))
+		t.Fatal()
+	}
+	resolver.LookupAnswersForType("baz.disco.net.", dns.TypeA)
+
+	resolver.health.CheckAll()
+
+	records, _ = resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+	if len(records) != 3 {
+		t.Error("Expected three answers, got ", len(records))
+		t.Fatal()
+	}
+	for _, rr := range records {
+		if rr.(*dns.A).A.String() == "2.2.2.2" {
+			t.Error("Expected the unhealthy record to be excluded")
+			t.Fatal()
+		}
+	}
+
+	// Removing the health key makes the JSON record unhealthy
+	client.Delete("TestLookupAnswerHealthChecks/health/3", false)
+	resolver.health.CheckAll()
+
+	records, _ = resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	// When every record is unhealthy they're all returned
+	records, _ = resolver.LookupAnswersForType("baz.disco.net.", dns.TypeA)
+	if len(records) != 1 {
+		t.Error("Expected the only (unhealthy) record to be returned, got ", len(records))
+		t.Fatal()
+	}
+}
+
+/**
+ * Test converstion of names (i.e etcd nodes) to single records of different
+ * types.
+ **/
+
+func TestLookupAnswerForA(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForA/"
+	client.Set("TestLookupAnswerForA/net/disco/bar/.A", "1.2.3.4", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.A)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeA {
+		t.Error("Expected record with type A:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.A.String() != "1.2.3.4" {
+		t.Error("Expected A record to be 1.2.3.4: ", rr.A)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForAAAA(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForAAAA/"
+	client.Set("TestLookupAnswerForAAAA/net/disco/bar/.AAAA", "::1", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeAAAA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.AAAA)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeAAAA {
+		t.Error("Expected record with type AAAA:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.AAAA.String() != "::1" {
+		t.Error("Expected AAAA record to be ::1: ", rr.AAAA)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForCNAME(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForCNAME/"
+	client.Set("TestLookupAnswerForCNAME/net/disco/bar/.CNAME", "cname.google.com.", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeCNAME)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.CNAME)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeCNAME {
+		t.Error("Expected record with type CNAME:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.Target != "cname.google.com." {
+		t.Error("Expected CNAME record to be cname.google.com.: ", rr.Target)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForDNAME(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForDNAME/"
+	client.Set("TestLookupAnswerForDNAME/net/disco/bar/.DNAME", "baz.disco.net", 0)
+	client.Set("TestLookupAnswerForDNAME/net/disco/bad/.DNAME", "...", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeDNAME)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.DNAME)
+	header := rr.Header()
+
+	if header.Rrtype != dns.TypeDNAME {
+		t.Error("Expected record with type DNAME:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.Target != "baz.disco.net." {
+		t.Error("Expected DNAME record to be baz.disco.net.: ", rr.Target)
+		t.Fatal()
+	}
+
+	records, err := resolver.LookupAnswersForType("bad.disco.net.", dns.TypeDNAME)
+
+	if len(records) > 0 {
+		t.Error("Expected no answers, got ", len(records))
+		t.Fatal()
+	}
+
+	if err == nil {
+		t.Error("Expected error, didn't get one")
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForNS(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForNS/"
+	client.Set("TestLookupAnswerForNS/net/disco/bar/.NS", "dns.google.com.", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
 	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeNS)
@@ -835,196 +1987,1356 @@ func TestLookupAnswerForNS(t *testing.T) {
 		t.Fatal()
 	}
 
-	rr := records[0].(*dns.NS)
+	rr := records[0].(*dns.NS)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeNS {
+		t.Error("Expected record with type NS:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.Ns != "dns.google.com." {
+		t.Error("Expected NS record to be dns.google.com.: ", rr.Ns)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForSOA(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSOA/"
+	client.Set("TestLookupAnswerForSOA/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("disco.net.", dns.TypeSOA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.SOA)
+	header := rr.Header()
+
+	if header.Name != "disco.net." {
+		t.Error("Expected record with name disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeSOA {
+		t.Error("Expected record with type SOA:", header.Rrtype)
+		t.Fatal()
+	}
+
+	// Verify the record itself is correct
+	if rr.Ns != "ns1.disco.net." {
+		t.Error("Expected NS to be ns1.disco.net.: ", rr.Ns)
+		t.Fatal()
+	}
+	if rr.Mbox != "admin.disco.net." {
+		t.Error("Expected MBOX to be admin.disco.net.: ", rr.Mbox)
+		t.Fatal()
+	}
+	if rr.Refresh != 3600 {
+		t.Error("Expected REFRESH to be 3600: ", rr.Refresh)
+		t.Fatal()
+	}
+	if rr.Retry != 600 {
+		t.Error("Expected RETRY to be 600: ", rr.Retry)
+		t.Fatal()
+	}
+	if rr.Expire != 86400 {
+		t.Error("Expected EXPIRE to be 86400: ", rr.Expire)
+		t.Fatal()
+	}
+	if rr.Minttl != 10 {
+		t.Error("Expected MINTTL to be 10: ", rr.Minttl)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForPTR(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForPTR/"
+	client.Set("TestLookupAnswerForPTR/net/disco/alias/.PTR/target1", "target1.disco.net.", 0)
+	client.Set("TestLookupAnswerForPTR/net/disco/alias/.PTR/target2", "target2.disco.net.", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("alias.disco.net.", dns.TypePTR)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	seen_1 := false
+	seen_2 := false
+
+	// We can't (and shouldn't try to) guarantee order, so check for all
+	// expected records the long way
+	for _, record := range records {
+		rr := record.(*dns.PTR)
+		header := rr.Header()
+
+		if header.Rrtype != dns.TypePTR {
+			t.Error("Expected record with type PTR:", header.Rrtype)
+			t.Fatal()
+		}
+
+		t.Log(rr)
+
+		if rr.Ptr == "target1.disco.net." {
+			seen_1 = true
+		}
+
+		if rr.Ptr == "target2.disco.net." {
+			seen_2 = true
+		}
+	}
+
+	if seen_1 == false || seen_2 == false {
+		t.Error("Didn't get back all expected PTR responses")
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForPTRInvalidDomain(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForPTRInvalidDomain/"
+	client.Set("TestLookupAnswerForPTRInvalidDomain/net/disco/bad-alias/.PTR", "...", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, err := resolver.LookupAnswersForType("bad-alias.disco.net.", dns.TypePTR)
+
+	if len(records) > 0 {
+		t.Error("Expected no answers, got ", len(records))
+		t.Fatal()
+	}
+
+	if err == nil {
+		t.Error("Expected error, didn't get one")
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForSRV(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSRV/"
+	client.Set("TestLookupAnswerForSRV/net/disco/_tcp/_http/.SRV",
+		"100\t100\t80\tsome-webserver.disco.net",
+		0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("_http._tcp.disco.net.", dns.TypeSRV)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.SRV)
+
+	if rr.Priority != 100 {
+		t.Error("Unexpected 'priority' value for SRV record:", rr.Priority)
+	}
+
+	if rr.Weight != 100 {
+		t.Error("Unexpected 'weight' value for SRV record:", rr.Weight)
+	}
+
+	if rr.Port != 80 {
+		t.Error("Unexpected 'port' value for SRV record:", rr.Port)
+	}
+
+	if rr.Target != "some-webserver.disco.net." {
+		t.Error("Unexpected 'target' value for SRV record:", rr.Target)
+	}
+}
+
+func TestLookupAnswerForSRVInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSRVInvalidValues/"
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var bad_vals_map = map[string]string{
+		"wrong-delimiter":    "10 10 80 foo.disco.net",
+		"not-enough-fields":  "0\t0",
+		"neg-int-priority":   "-10\t10\t80\tfoo.disco.net",
+		"neg-int-weight":     "10\t-10\t80\tfoo.disco.net",
+		"neg-int-port":       "10\t10\t-80\tfoo.disco.net",
+		"large-int-priority": "65536\t10\t80\tfoo.disco.net",
+		"large-int-weight":   "10\t65536\t80\tfoo.disco.net",
+		"large-int-port":     "10\t10\t65536\tfoo.disco.net"}
+
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForSRVInvalidValues/net/disco/"+name+"/.SRV", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeSRV)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if err == nil {
+			t.Error("Expected error, didn't get one")
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForTXT(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForTXT/"
+	client.Set("TestLookupAnswerForTXT/net/disco/bar/.TXT", "v=spf1 include:disco.net\t~all", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.TXT)
+
+	if len(rr.Txt) != 2 {
+		t.Error("Expected two strings, got ", len(rr.Txt))
+		t.Fatal()
+	}
+	if rr.Txt[0] != "v=spf1 include:disco.net" || rr.Txt[1] != "~all" {
+		t.Error("Unexpected strings for TXT record:", rr.Txt)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForTXTLongValue(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForTXTLongValue/"
+	client.Set("TestLookupAnswerForTXTLongValue/net/disco/foo/.TXT", strings.Repeat("a", 600), 0)
+	// Escape sequences count as a single byte, and mustn't be split
+	client.Set("TestLookupAnswerForTXTLongValue/net/disco/bar/.TXT", strings.Repeat("a", 254)+"\\065b", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("foo.disco.net.", dns.TypeTXT)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.TXT)
+
+	if len(rr.Txt) != 3 || len(rr.Txt[0]) != 255 || len(rr.Txt[1]) != 255 || len(rr.Txt[2]) != 90 {
+		t.Error("Expected strings of 255, 255 and 90 bytes:", rr.Txt)
+		t.Fatal()
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = records
+	if _, err := msg.Pack(); err != nil {
+		t.Error("Unable to pack TXT record:", err)
+		t.Fatal()
+	}
+
+	records, _ = resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+	rr = records[0].(*dns.TXT)
+
+	if len(rr.Txt) != 2 || rr.Txt[1] != "b" {
+		t.Error("Expected the escape sequence to end the first string:", rr.Txt)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForTXTTooLong(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForTXTTooLong/"
+	client.Set("TestLookupAnswerForTXTTooLong/net/disco/bar/.TXT", strings.Repeat("a", 65535), 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, err := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+
+	if len(records) > 0 {
+		t.Error("Expected no answers, got ", len(records))
+		t.Fatal()
+	}
+
+	if _, ok := err.(*NodeConversionError); !ok {
+		t.Error("Expected NodeConversionError, got", err)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForJSONValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForJSONValues/"
+	client.Set("TestLookupAnswerForJSONValues/net/disco/bar/.A/0", `{"host": "1.2.3.4", "ttl": 60, "comment": "web"}`, 0)
+	client.Set("TestLookupAnswerForJSONValues/net/disco/bar/.A/1", "2.3.4.5", 0)
+	client.Set("TestLookupAnswerForJSONValues/net/disco/bar/.SRV", `{"priority": 10, "weight": 20, "port": 8080, "host": "bar.disco.net"}`, 0)
+	client.Set("TestLookupAnswerForJSONValues/net/disco/bar/.TXT", `{"text": ["foo", "bar"]}`, 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	a := records[0].(*dns.A)
+	if a.A.String() != "1.2.3.4" {
+		t.Error("Expected first record to be 1.2.3.4: ", a.A.String())
+		t.Fatal()
+	}
+	if a.Header().Ttl != 60 {
+		t.Error("Expected first record to have TTL 60: ", a.Header().Ttl)
+		t.Fatal()
+	}
+	if records[1].Header().Ttl != resolver.defaultTtl {
+		t.Error("Expected second record to have the default TTL: ", records[1].Header().Ttl)
+		t.Fatal()
+	}
+
+	records, _ = resolver.LookupAnswersForType("bar.disco.net.", dns.TypeSRV)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	srv := records[0].(*dns.SRV)
+	if srv.Priority != 10 || srv.Weight != 20 || srv.Port != 8080 || srv.Target != "bar.disco.net." {
+		t.Error("Unexpected SRV record:", srv.String())
+		t.Fatal()
+	}
+
+	records, _ = resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	txt := records[0].(*dns.TXT)
+	if len(txt.Txt) != 2 || txt.Txt[0] != "foo" || txt.Txt[1] != "bar" {
+		t.Error("Unexpected strings for TXT record:", txt.Txt)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForJSONInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForJSONInvalidValues/"
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var bad_vals_map = map[string]string{
+		"missing-host": `{"ttl": 60}`,
+		"object-host":  `{"host": {"ip": "1.2.3.4"}}`,
+		"list-of-ints": `{"host": [1, 2]}`,
+		"invalid-host": `{"host": "1.2.3"}`}
+
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForJSONInvalidValues/net/disco/"+name+"/.A", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeA)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError for", name, "got", err)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForJSONMissingFields(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForJSONMissingFields/"
+	client.Set("TestLookupAnswerForJSONMissingFields/net/disco/bar/.SRV", `{"priority": 10, "weight": 20, "host": "bar.disco.net"}`, 0)
+	client.Set("TestLookupAnswerForJSONMissingFields/net/disco/bar/.TXT", `{"service":"web","port":80}`, 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, err := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeSRV)
+
+	if len(records) > 0 {
+		t.Error("Expected no answers, got ", len(records))
+		t.Fatal()
+	}
+	if _, ok := err.(*NodeConversionError); !ok {
+		t.Error("Expected NodeConversionError, got", err)
+		t.Fatal()
+	}
+
+	// TXT records without a text field are plain text that happens to be JSON
+	records, err = resolver.LookupAnswersForType("bar.disco.net.", dns.TypeTXT)
+
+	if err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	txt := records[0].(*dns.TXT)
+	if len(txt.Txt) != 1 || txt.Txt[0] != `{"service":"web","port":80}` {
+		t.Error("Unexpected strings for TXT record:", txt.Txt)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForSkyDNS(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSkyDNS/"
+	resolver.skydnsPrefix = "TestLookupAnswerForSkyDNS/skydns"
+	client.Set("TestLookupAnswerForSkyDNS/skydns/local/cluster/svc/a", `{"host": "10.0.0.1", "port": 8080, "ttl": 60}`, 0)
+	client.Set("TestLookupAnswerForSkyDNS/skydns/local/cluster/svc/b", `{"host": "::1", "port": 8081, "priority": 20}`, 0)
+	client.Set("TestLookupAnswerForSkyDNS/skydns/local/cluster/svc/c", `{"host": "web.disco.net", "port": 80, "text": "hello"}`, 0)
+	client.Set("TestLookupAnswerForSkyDNS/skydns/local/cluster/svc/d", "not json", 0)
+	defer func() { resolver.skydnsPrefix = "" }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("svc.cluster.local.", dns.TypeA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	a := records[0].(*dns.A)
+	if a.A.String() != "10.0.0.1" || a.Header().Ttl != 60 {
+		t.Error("Unexpected A record:", a.String())
+		t.Fatal()
+	}
+
+	records, _ = resolver.LookupAnswersForType("svc.cluster.local.", dns.TypeAAAA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	if records[0].Header().Ttl != resolver.defaultTtl {
+		t.Error("Expected record with the default TTL:", records[0].Header().Ttl)
+		t.Fatal()
+	}
+
+	records, _ = resolver.LookupAnswersForType("svc.cluster.local.", dns.TypeSRV)
+
+	if len(records) != 3 {
+		t.Error("Expected three answers, got ", len(records))
+		t.Fatal()
+	}
+
+	var expected = []dns.SRV{
+		{Priority: 10, Port: 8080, Target: "a.svc.cluster.local."},
+		{Priority: 20, Port: 8081, Target: "b.svc.cluster.local."},
+		{Priority: 10, Port: 80, Target: "web.disco.net."}}
+
+	for i, rr := range records {
+		srv := rr.(*dns.SRV)
+		if srv.Header().Name != "svc.cluster.local." {
+			t.Error("Expected record with name svc.cluster.local.: ", srv.Header().Name)
+			t.Fatal()
+		}
+		if srv.Priority != expected[i].Priority || srv.Port != expected[i].Port || srv.Target != expected[i].Target {
+			t.Error("Unexpected SRV record:", srv.String())
+			t.Fatal()
+		}
+	}
+
+	// The synthesized SRV targets resolve too
+	records, _ = resolver.LookupAnswersForType("a.svc.cluster.local.", dns.TypeA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	records, _ = resolver.LookupAnswersForType("svc.cluster.local.", dns.TypeTXT)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	if txt := records[0].(*dns.TXT); txt.Txt[0] != "hello" {
+		t.Error("Unexpected TXT record:", txt.String())
+		t.Fatal()
+	}
+}
+
+func TestLookupSkyDNSNoData(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupSkyDNSNoData/"
+	resolver.skydnsPrefix = "TestLookupSkyDNSNoData/skydns"
+	client.Set("TestLookupSkyDNSNoData/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestLookupSkyDNSNoData/skydns/net/disco/svc/a", `{"host": "10.0.0.1"}`, 0)
+	defer func() { resolver.skydnsPrefix = "" }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	query := new(dns.Msg)
+	query.SetQuestion("svc.disco.net.", dns.TypeAAAA)
+
+	answer := resolver.Lookup(query)
+
+	if answer.Rcode != dns.RcodeSuccess || len(answer.Answer) != 0 {
+		t.Error("Expected a NODATA response for a SkyDNS name, got", dns.RcodeToString[answer.Rcode], answer.Answer)
+		t.Fatal()
+	}
+
+	query.SetQuestion("other.disco.net.", dns.TypeAAAA)
+	answer = resolver.Lookup(query)
+
+	if answer.Rcode != dns.RcodeNameError {
+		t.Error("Expected NXDOMAIN response code, got", dns.RcodeToString[answer.Rcode])
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForMX(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForMX/"
+	client.Set("TestLookupAnswerForMX/net/disco/.MX/0", "10\tmx1.disco.net", 0)
+	client.Set("TestLookupAnswerForMX/net/disco/.MX/1", "20\tmx2.disco.net.", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("disco.net.", dns.TypeMX)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.MX)
+	header := rr.Header()
+
+	if header.Name != "disco.net." {
+		t.Error("Expected record with name disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeMX {
+		t.Error("Expected record with type MX:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.Preference != 10 {
+		t.Error("Unexpected 'preference' value for MX record:", rr.Preference)
+	}
+	if rr.Mx != "mx1.disco.net." {
+		t.Error("Unexpected 'exchange' value for MX record:", rr.Mx)
+	}
+
+	rr = records[1].(*dns.MX)
+	if rr.Preference != 20 {
+		t.Error("Unexpected 'preference' value for MX record:", rr.Preference)
+	}
+	if rr.Mx != "mx2.disco.net." {
+		t.Error("Unexpected 'exchange' value for MX record:", rr.Mx)
+	}
+}
+
+func TestLookupAnswerForMXInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForMXInvalidValues/"
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var bad_vals_map = map[string]string{
+		"wrong-delimiter":      "10 mx.disco.net",
+		"not-enough-fields":    "10",
+		"neg-int-preference":   "-10\tmx.disco.net",
+		"large-int-preference": "65536\tmx.disco.net",
+		"bad-exchange":         "10\t..."}
+
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForMXInvalidValues/net/disco/"+name+"/.MX", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeMX)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if err == nil {
+			t.Error("Expected error, didn't get one")
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError, got", err)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForCAA(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForCAA/"
+	client.Set("TestLookupAnswerForCAA/net/disco/.CAA/0", "0\tissue\tca.disco.net; account=1234", 0)
+	client.Set("TestLookupAnswerForCAA/net/disco/.CAA/1", "128\tissuewild\t;", 0)
+	client.Set("TestLookupAnswerForCAA/net/disco/.CAA/2", "0\tiodef\tmailto:security@disco.net", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("disco.net.", dns.TypeCAA)
+
+	if len(records) != 3 {
+		t.Error("Expected three answers, got ", len(records))
+		t.Fatal()
+	}
+
+	expected := []string{
+		"0005" + hex.EncodeToString([]byte("issueca.disco.net; account=1234")),
+		"8009" + hex.EncodeToString([]byte("issuewild;")),
+		"0005" + hex.EncodeToString([]byte("iodefmailto:security@disco.net"))}
+
+	for i, record := range records {
+		rr := record.(*dns.RFC3597)
+		header := rr.Header()
+
+		if header.Rrtype != dns.TypeCAA {
+			t.Error("Expected record with type CAA:", header.Rrtype)
+			t.Fatal()
+		}
+		if rr.Rdata != expected[i] {
+			t.Error("Expected CAA rdata to be", expected[i], ":", rr.Rdata)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForCAAInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForCAAInvalidValues/"
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var bad_vals_map = map[string]string{
+		"wrong-delimiter":   "0 issue ca.disco.net",
+		"not-enough-fields": "0\tissue",
+		"large-int-flags":   "256\tissue\tca.disco.net",
+		"unknown-tag":       "0\tissuer\tca.disco.net",
+		"bad-issuer":        "0\tissue\t...",
+		"bad-iodef":         "0\tiodef\tftp://disco.net/"}
+
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForCAAInvalidValues/net/disco/"+name+"/.CAA", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeCAA)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError, got", err)
+			t.Fatal()
+		}
+	}
+}
+
+func TestAnswerQuestionCAACNAME(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionCAACNAME/"
+	client.Set("TestAnswerQuestionCAACNAME/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestAnswerQuestionCAACNAME/net/disco/bar/.CNAME", "baz.disco.net.", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	// CAA queries for a name with only a CNAME get the CNAME, so the CA can
+	// follow it to the target's CAA records
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeCAA)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(answer.Answer))
+		t.Fatal()
+	}
+
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Error("Expected NOERROR response code, got", dns.RcodeToString[answer.Rcode])
+		t.Fatal()
+	}
+
+	rr := answer.Answer[0].(*dns.CNAME)
+	if rr.Target != "baz.disco.net." {
+		t.Error("Expected CNAME target baz.disco.net.:", rr.Target)
+		t.Fatal()
+	}
+}
+
+func TestLookupAnswerForSSHFP(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSSHFP/"
+	client.Set("TestLookupAnswerForSSHFP/net/disco/bar/.SSHFP",
+		"4\t2\t8C7A5F2F3A2E5F3D1B9C0E7F7A6B5C4D3E2F1A0B9C8D7E6F5A4B3C2D1E0F9A8B",
+		0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeSSHFP)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.SSHFP)
+	header := rr.Header()
+
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeSSHFP {
+		t.Error("Expected record with type SSHFP:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.Algorithm != 4 {
+		t.Error("Unexpected 'algorithm' value for SSHFP record:", rr.Algorithm)
+	}
+	if rr.Type != 2 {
+		t.Error("Unexpected 'type' value for SSHFP record:", rr.Type)
+	}
+	if rr.FingerPrint != "8c7a5f2f3a2e5f3d1b9c0e7f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b" {
+		t.Error("Unexpected 'fingerprint' value for SSHFP record:", rr.FingerPrint)
+	}
+}
+
+func TestLookupAnswerForSSHFPInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSSHFPInvalidValues/"
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var bad_vals_map = map[string]string{
+		"wrong-delimiter":   "4 2 8c7a5f2f",
+		"not-enough-fields": "4\t2",
+		"large-int-algo":    "256\t2\t8c7a5f2f",
+		"neg-int-type":      "4\t-2\t8c7a5f2f",
+		"invalid-hex":       "4\t2\t8c7a5f2g",
+		"odd-length-hex":    "4\t2\t8c7a5f2",
+		"empty-fingerprint": "4\t2\t"}
+
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForSSHFPInvalidValues/net/disco/"+name+"/.SSHFP", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeSSHFP)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError, got", err)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForTLSA(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForTLSA/"
+	client.Set("TestLookupAnswerForTLSA/net/disco/bar/_tcp/_443/.TLSA",
+		"3\t1\t1\t0D6FCE3330A8A9F6E6C2F0C7A5B8E5D8F0C3A2B1E9D8C7B6A5F4E3D2C1B0A9F8",
+		0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("_443._tcp.bar.disco.net.", dns.TypeTLSA)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.TLSA)
+	header := rr.Header()
+
+	if header.Name != "_443._tcp.bar.disco.net." {
+		t.Error("Expected record with name _443._tcp.bar.disco.net.: ", header.Name)
+		t.Fatal()
+	}
+	if header.Rrtype != dns.TypeTLSA {
+		t.Error("Expected record with type TLSA:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.Usage != 3 {
+		t.Error("Unexpected 'usage' value for TLSA record:", rr.Usage)
+	}
+	if rr.Selector != 1 {
+		t.Error("Unexpected 'selector' value for TLSA record:", rr.Selector)
+	}
+	if rr.MatchingType != 1 {
+		t.Error("Unexpected 'matching type' value for TLSA record:", rr.MatchingType)
+	}
+	if rr.Certificate != "0d6fce3330a8a9f6e6c2f0c7a5b8e5d8f0c3a2b1e9d8c7b6a5f4e3d2c1b0a9f8" {
+		t.Error("Unexpected 'certificate' value for TLSA record:", rr.Certificate)
+	}
+}
+
+func TestLookupAnswerForTLSAInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForTLSAInvalidValues/"
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var bad_vals_map = map[string]string{
+		"wrong-delimiter":    "3 1 1 0d6fce33",
+		"not-enough-fields":  "3\t1\t1",
+		"large-int-usage":    "256\t1\t1\t0d6fce33",
+		"neg-int-selector":   "3\t-1\t1\t0d6fce33",
+		"large-int-matching": "3\t1\t256\t0d6fce33",
+		"invalid-hex":        "3\t1\t1\tnothex",
+		"empty-certificate":  "3\t1\t1\t"}
+
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForTLSAInvalidValues/net/disco/"+name+"/.TLSA", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeTLSA)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError, got", err)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForNAPTR(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForNAPTR/"
+	client.Set("TestLookupAnswerForNAPTR/net/disco/.NAPTR/0", "100\t10\tS\tSIP+D2U\t\t_sip._udp.disco.net.", 0)
+	client.Set("TestLookupAnswerForNAPTR/net/disco/.NAPTR/1", "100\t20\tU\tE2U+sip\t!^.*$!sip:info@disco.net!\t", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("disco.net.", dns.TypeNAPTR)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.NAPTR)
+	header := rr.Header()
+
+	if header.Rrtype != dns.TypeNAPTR {
+		t.Error("Expected record with type NAPTR:", header.Rrtype)
+		t.Fatal()
+	}
+	if rr.Order != 100 {
+		t.Error("Unexpected 'order' value for NAPTR record:", rr.Order)
+	}
+	if rr.Preference != 10 {
+		t.Error("Unexpected 'preference' value for NAPTR record:", rr.Preference)
+	}
+	if rr.Flags != "S" {
+		t.Error("Unexpected 'flags' value for NAPTR record:", rr.Flags)
+	}
+	if rr.Service != "SIP+D2U" {
+		t.Error("Unexpected 'service' value for NAPTR record:", rr.Service)
+	}
+	if rr.Regexp != "" {
+		t.Error("Unexpected 'regexp' value for NAPTR record:", rr.Regexp)
+	}
+	if rr.Replacement != "_sip._udp.disco.net." {
+		t.Error("Unexpected 'replacement' value for NAPTR record:", rr.Replacement)
+	}
+
+	rr = records[1].(*dns.NAPTR)
+	if rr.Regexp != "!^.*$!sip:info@disco.net!" {
+		t.Error("Unexpected 'regexp' value for NAPTR record:", rr.Regexp)
+	}
+	if rr.Replacement != "." {
+		t.Error("Unexpected 'replacement' value for NAPTR record:", rr.Replacement)
+	}
+}
+
+func TestLookupAnswerForNAPTRInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForNAPTRInvalidValues/"
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	var bad_vals_map = map[string]string{
+		"not-enough-fields":    "100\t10\tS\tSIP+D2U\t",
+		"neg-int-order":        "-100\t10\tS\tSIP+D2U\t\t_sip._udp.disco.net.",
+		"large-int-preference": "100\t65536\tS\tSIP+D2U\t\t_sip._udp.disco.net.",
+		"bad-flags":            "100\t10\tS!\tSIP+D2U\t\t_sip._udp.disco.net.",
+		"bad-replacement":      "100\t10\tS\tSIP+D2U\t\t...",
+		"regexp-replacement":   "100\t10\tU\tE2U+sip\t!^.*$!sip:info@disco.net!\t_sip._udp.disco.net."}
+
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForNAPTRInvalidValues/net/disco/"+name+"/.NAPTR", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeNAPTR)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError, got", err)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForURI(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForURI/"
+	client.Set("TestLookupAnswerForURI/net/disco/_tcp/_http/.URI", "10\t1\thttp://www.disco.net/path", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("_http._tcp.disco.net.", dns.TypeURI)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	rr := records[0].(*dns.RFC3597)
 	header := rr.Header()
 
-	if header.Name != "bar.disco.net." {
-		t.Error("Expected record with name bar.disco.net.: ", header.Name)
-		t.Fatal()
-	}
-	if header.Rrtype != dns.TypeNS {
-		t.Error("Expected record with type NS:", header.Rrtype)
+	if header.Rrtype != dns.TypeURI {
+		t.Error("Expected record with type URI:", header.Rrtype)
 		t.Fatal()
 	}
-	if rr.Ns != "dns.google.com." {
-		t.Error("Expected NS record to be dns.google.com.: ", rr.Ns)
+
+	expected := "000a0001" + hex.EncodeToString([]byte("http://www.disco.net/path"))
+	if rr.Rdata != expected {
+		t.Error("Expected URI rdata to be", expected, ":", rr.Rdata)
 		t.Fatal()
 	}
 }
 
-func TestLookupAnswerForSOA(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForSOA/"
-	client.Set("TestLookupAnswerForSOA/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+func TestLookupAnswerForURIInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForURIInvalidValues/"
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("disco.net.", dns.TypeSOA)
+	var bad_vals_map = map[string]string{
+		"wrong-delimiter":   "10 1 http://www.disco.net/",
+		"not-enough-fields": "10\t1",
+		"neg-int-priority":  "-10\t1\thttp://www.disco.net/",
+		"large-int-weight":  "10\t65536\thttp://www.disco.net/",
+		"no-scheme":         "10\t1\twww.disco.net",
+		"invalid-uri":       "10\t1\thttp://[::1"}
 
-	if len(records) != 1 {
-		t.Error("Expected one answer, got ", len(records))
-		t.Fatal()
+	for name, value := range bad_vals_map {
+
+		client.Set("TestLookupAnswerForURIInvalidValues/net/disco/"+name+"/.URI", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeURI)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers, got ", len(records))
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError, got", err)
+			t.Fatal()
+		}
 	}
+}
 
-	rr := records[0].(*dns.SOA)
-	header := rr.Header()
+func TestAnswerQuestionAdditionalRecords(t *testing.T) {
+	resolver.etcdPrefix = "TestAnswerQuestionAdditionalRecords/"
+	client.Set("TestAnswerQuestionAdditionalRecords/net/disco/.NAPTR/0", "100\t10\tS\tSIP+D2U\t\t_sip._udp.disco.net.", 0)
+	client.Set("TestAnswerQuestionAdditionalRecords/net/disco/.NAPTR/1", "100\t20\tA\tSIP+D2T\t\tsip.disco.net.", 0)
+	client.Set("TestAnswerQuestionAdditionalRecords/net/disco/_udp/_sip/.SRV", "10\t10\t5060\tsip.disco.net.", 0)
+	client.Set("TestAnswerQuestionAdditionalRecords/net/disco/sip/.A", "1.2.3.4", 0)
+	client.Set("TestAnswerQuestionAdditionalRecords/net/disco/_tcp/_http/.URI", "10\t1\thttp://www.disco.net:8080/", 0)
+	client.Set("TestAnswerQuestionAdditionalRecords/net/disco/www/.AAAA", "::1", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
 
-	if header.Name != "disco.net." {
-		t.Error("Expected record with name disco.net.: ", header.Name)
+	query := new(dns.Msg)
+	query.SetQuestion("disco.net.", dns.TypeNAPTR)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 2 {
+		t.Error("Expected two answers, got ", len(answer.Answer))
 		t.Fatal()
 	}
-	if header.Rrtype != dns.TypeSOA {
-		t.Error("Expected record with type SOA:", header.Rrtype)
+
+	if len(answer.Extra) != 2 {
+		t.Error("Expected two additional records, got ", len(answer.Extra))
 		t.Fatal()
 	}
 
-	// Verify the record itself is correct
-	if rr.Ns != "ns1.disco.net." {
-		t.Error("Expected NS to be ns1.disco.net.: ", rr.Ns)
+	if answer.Extra[0].Header().Rrtype != dns.TypeSRV {
+		t.Error("Expected additional record with type SRV:", answer.Extra[0].Header().Rrtype)
 		t.Fatal()
 	}
-	if rr.Mbox != "admin.disco.net." {
-		t.Error("Expected MBOX to be admin.disco.net.: ", rr.Mbox)
+	if answer.Extra[1].Header().Rrtype != dns.TypeA {
+		t.Error("Expected additional record with type A:", answer.Extra[1].Header().Rrtype)
 		t.Fatal()
 	}
-	if rr.Refresh != 3600 {
-		t.Error("Expected REFRESH to be 3600: ", rr.Refresh)
+
+	query = new(dns.Msg)
+	query.SetQuestion("_http._tcp.disco.net.", dns.TypeURI)
+
+	answer = resolver.Lookup(query)
+
+	if len(answer.Extra) != 1 {
+		t.Error("Expected one additional record, got ", len(answer.Extra))
 		t.Fatal()
 	}
-	if rr.Retry != 600 {
-		t.Error("Expected RETRY to be 600: ", rr.Retry)
+
+	if answer.Extra[0].Header().Name != "www.disco.net." {
+		t.Error("Expected additional record with name www.disco.net.:", answer.Extra[0].Header().Name)
 		t.Fatal()
 	}
-	if rr.Expire != 86400 {
-		t.Error("Expected EXPIRE to be 86400: ", rr.Expire)
+}
+
+func TestLookupAnswerForSVCB(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSVCB/"
+	client.Set("TestLookupAnswerForSVCB/net/disco/_8443/_foo/api/.SVCB",
+		"1\tsvc.disco.net.\tport=8443 alpn=h2,h3 ipv4hint=10.0.0.1",
+		0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("api._foo._8443.disco.net.", TypeSVCB)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
 		t.Fatal()
 	}
-	if rr.Minttl != 10 {
-		t.Error("Expected MINTTL to be 10: ", rr.Minttl)
+
+	rr := records[0].(*dns.RFC3597)
+	header := rr.Header()
+
+	if header.Rrtype != TypeSVCB {
+		t.Error("Expected record with type SVCB:", header.Rrtype)
+		t.Fatal()
+	}
+
+	// Params should be ordered by key
+	expected := "0001" +
+		"0373766305646973636f036e657400" +
+		"00010006026832026833" +
+		"0003000220fb" +
+		"000400040a000001"
+	if rr.Rdata != expected {
+		t.Error("Expected SVCB rdata to be", expected, ":", rr.Rdata)
 		t.Fatal()
 	}
 }
 
-func TestLookupAnswerForPTR(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForPTR/"
-	client.Set("TestLookupAnswerForPTR/net/disco/alias/.PTR/target1", "target1.disco.net.", 0)
-	client.Set("TestLookupAnswerForPTR/net/disco/alias/.PTR/target2", "target2.disco.net.", 0)
+func TestLookupAnswerForHTTPS(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForHTTPS/"
+	client.Set("TestLookupAnswerForHTTPS/net/disco/.HTTPS/0", "0\tcdn.disco.net", 0)
+	client.Set("TestLookupAnswerForHTTPS/net/disco/.HTTPS/1", "1\t.\tipv6hint=::1 ech=AQI=", 0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("alias.disco.net.", dns.TypePTR)
+	records, _ := resolver.LookupAnswersForType("disco.net.", TypeHTTPS)
 
 	if len(records) != 2 {
 		t.Error("Expected two answers, got ", len(records))
 		t.Fatal()
 	}
 
-	seen_1 := false
-	seen_2 := false
-
-	// We can't (and shouldn't try to) guarantee order, so check for all
-	// expected records the long way
-	for _, record := range records {
-		rr := record.(*dns.PTR)
-		header := rr.Header()
-
-		if header.Rrtype != dns.TypePTR {
-			t.Error("Expected record with type PTR:", header.Rrtype)
-			t.Fatal()
-		}
-
-		t.Log(rr)
+	rr := records[0].(*dns.RFC3597)
+	header := rr.Header()
 
-		if rr.Ptr == "target1.disco.net." {
-			seen_1 = true
-		}
+	if header.Rrtype != TypeHTTPS {
+		t.Error("Expected record with type HTTPS:", header.Rrtype)
+		t.Fatal()
+	}
 
-		if rr.Ptr == "target2.disco.net." {
-			seen_2 = true
-		}
+	expected := "0000" + "0363646e05646973636f036e657400"
+	if rr.Rdata != expected {
+		t.Error("Expected HTTPS rdata to be", expected, ":", rr.Rdata)
+		t.Fatal()
 	}
 
-	if seen_1 == false || seen_2 == false {
-		t.Error("Didn't get back all expected PTR responses")
+	rr = records[1].(*dns.RFC3597)
+	expected = "0001" + "00" +
+		"000500020102" +
+		"0006001000000000000000000000000000000001"
+	if rr.Rdata != expected {
+		t.Error("Expected HTTPS rdata to be", expected, ":", rr.Rdata)
 		t.Fatal()
 	}
 }
 
-func TestLookupAnswerForPTRInvalidDomain(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForPTRInvalidDomain/"
-	client.Set("TestLookupAnswerForPTRInvalidDomain/net/disco/bad-alias/.PTR", "...", 0)
+func TestLookupAnswerForSVCBInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForSVCBInvalidValues/"
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, err := resolver.LookupAnswersForType("bad-alias.disco.net.", dns.TypePTR)
+	var bad_vals_map = map[string]string{
+		"not-enough-fields":  "1",
+		"neg-int-priority":   "-1\tsvc.disco.net.",
+		"bad-target":         "1\t...",
+		"alias-mode-params":  "0\tsvc.disco.net.\tport=443",
+		"unknown-key":        "1\tsvc.disco.net.\tfoo=bar",
+		"missing-value":      "1\tsvc.disco.net.\talpn",
+		"duplicate-key":      "1\tsvc.disco.net.\tport=443 port=8443",
+		"empty-alpn":         "1\tsvc.disco.net.\talpn=h2,",
+		"large-int-port":     "1\tsvc.disco.net.\tport=65536",
+		"ipv6-in-ipv4hint":   "1\tsvc.disco.net.\tipv4hint=::1",
+		"ipv4-in-ipv6hint":   "1\tsvc.disco.net.\tipv6hint=10.0.0.1",
+		"invalid-ech-base64": "1\tsvc.disco.net.\tech=!!!"}
 
-	if len(records) > 0 {
-		t.Error("Expected no answers, got ", len(records))
-		t.Fatal()
-	}
+	for name, value := range bad_vals_map {
 
-	if err == nil {
-		t.Error("Expected error, didn't get one")
-		t.Fatal()
+		client.Set("TestLookupAnswerForSVCBInvalidValues/net/disco/"+name+"/.SVCB", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", TypeSVCB)
+
+		if len(records) > 0 {
+			t.Error("Expected no answers for", name, ", got ", len(records))
+			t.Fatal()
+		}
+
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError for", name, ", got", err)
+			t.Fatal()
+		}
 	}
 }
 
-func TestLookupAnswerForSRV(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForSRV/"
-	client.Set("TestLookupAnswerForSRV/net/disco/_tcp/_http/.SRV",
-		"100\t100\t80\tsome-webserver.disco.net",
+func TestLookupAnswerForGenericType(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForGenericType/"
+	client.Set("TestLookupAnswerForGenericType/net/disco/bar/.LOC",
+		"52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m",
 		0)
 	defer client.Delete(resolver.etcdPrefix, true)
 
-	records, _ := resolver.LookupAnswersForType("_http._tcp.disco.net.", dns.TypeSRV)
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeLOC)
 
 	if len(records) != 1 {
 		t.Error("Expected one answer, got ", len(records))
 		t.Fatal()
 	}
 
-	rr := records[0].(*dns.SRV)
+	rr := records[0].(*dns.LOC)
+	header := rr.Header()
 
-	if rr.Priority != 100 {
-		t.Error("Unexpected 'priority' value for SRV record:", rr.Priority)
+	if header.Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", header.Name)
+		t.Fatal()
 	}
-
-	if rr.Weight != 100 {
-		t.Error("Unexpected 'weight' value for SRV record:", rr.Weight)
+	if header.Rrtype != dns.TypeLOC {
+		t.Error("Expected record with type LOC:", header.Rrtype)
+		t.Fatal()
+	}
+	if header.Ttl != resolver.defaultTtl {
+		t.Error("Expected record with the default TTL:", header.Ttl)
+		t.Fatal()
 	}
+	if rr.Latitude == 0 || rr.Longitude == 0 {
+		t.Error("Unexpected location for LOC record:", rr.String())
+	}
+}
 
-	if rr.Port != 80 {
-		t.Error("Unexpected 'port' value for SRV record:", rr.Port)
+func TestLookupAnswerForGenericUnknownType(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForGenericUnknownType/"
+	client.Set("TestLookupAnswerForGenericUnknownType/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestLookupAnswerForGenericUnknownType/net/disco/bar/.TYPE65534", "\\# 4 0a00 0001", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", 65534)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(answer.Answer))
+		t.Fatal()
 	}
 
-	if rr.Target != "some-webserver.disco.net." {
-		t.Error("Unexpected 'target' value for SRV record:", rr.Target)
+	rr := answer.Answer[0].(*dns.RFC3597)
+
+	if rr.Header().Rrtype != 65534 {
+		t.Error("Expected record with type 65534:", rr.Header().Rrtype)
+		t.Fatal()
+	}
+	if rr.Rdata != "0a000001" {
+		t.Error("Unexpected record data:", rr.Rdata)
+		t.Fatal()
 	}
 }
 
-func TestLookupAnswerForSRVInvalidValues(t *testing.T) {
-	resolver.etcdPrefix = "TestLookupAnswerForSRVInvalidValues/"
+func TestLookupAnswerForGenericInvalidValues(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForGenericInvalidValues/"
 	defer client.Delete(resolver.etcdPrefix, true)
 
 	var bad_vals_map = map[string]string{
-		"wrong-delimiter":    "10 10 80 foo.disco.net",
-		"not-enough-fields":  "0\t0",
-		"neg-int-priority":   "-10\t10\t80\tfoo.disco.net",
-		"neg-int-weight":     "10\t-10\t80\tfoo.disco.net",
-		"neg-int-port":       "10\t10\t-80\tfoo.disco.net",
-		"large-int-priority": "65536\t10\t80\tfoo.disco.net",
-		"large-int-weight":   "10\t65536\t80\tfoo.disco.net",
-		"large-int-port":     "10\t10\t65536\tfoo.disco.net"}
+		"empty":          "",
+		"invalid-loc":    "somewhere over the rainbow",
+		"unknown-no-len": "\\#",
+		"unknown-len":    "\\# 3 0a00",
+		"unknown-hex":    "\\# 2 0g00"}
 
 	for name, value := range bad_vals_map {
 
-		client.Set("TestLookupAnswerForSRVInvalidValues/net/disco/"+name+"/.SRV", value, 0)
-		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeSRV)
+		client.Set("TestLookupAnswerForGenericInvalidValues/net/disco/"+name+"/.LOC", value, 0)
+		records, err := resolver.LookupAnswersForType(name+".disco.net.", dns.TypeLOC)
 
 		if len(records) > 0 {
 			t.Error("Expected no answers, got ", len(records))
 			t.Fatal()
 		}
 
-		if err == nil {
-			t.Error("Expected error, didn't get one")
+		if _, ok := err.(*NodeConversionError); !ok {
+			t.Error("Expected NodeConversionError for", name, "got", err)
+			t.Fatal()
+		}
+	}
+}
+
+func TestLookupAnswerForGenericRecords(t *testing.T) {
+	resolver.etcdPrefix = "TestLookupAnswerForGenericRecords/"
+	resolver.genericRecords = true
+	client.Set("TestLookupAnswerForGenericRecords/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestLookupAnswerForGenericRecords/net/disco/bar/.A", "1.2.3.4", 0)
+	client.Set("TestLookupAnswerForGenericRecords/net/disco/bar/.RR/0", "A 2.3.4.5", 0)
+	client.Set("TestLookupAnswerForGenericRecords/net/disco/bar/.RR/1", "MX 10 mail.disco.net.", 0)
+	client.Set("TestLookupAnswerForGenericRecords/net/disco/bar/.RR/2", "TYPE65534 \\# 2 abcd", 0)
+	defer func() { resolver.genericRecords = false }()
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	records, _ := resolver.LookupAnswersForType("bar.disco.net.", dns.TypeA)
+
+	if len(records) != 2 {
+		t.Error("Expected two answers, got ", len(records))
+		t.Fatal()
+	}
+
+	for _, rr := range records {
+		if rr.Header().Rrtype != dns.TypeA {
+			t.Error("Expected record with type A:", rr.Header().Rrtype)
 			t.Fatal()
 		}
 	}
+
+	records, _ = resolver.LookupAnswersForType("bar.disco.net.", dns.TypeMX)
+
+	if len(records) != 1 {
+		t.Error("Expected one answer, got ", len(records))
+		t.Fatal()
+	}
+
+	if rr := records[0].(*dns.MX); rr.Mx != "mail.disco.net." || rr.Preference != 10 {
+		t.Error("Unexpected MX record:", rr.String())
+		t.Fatal()
+	}
+
+	// ANY should return each record exactly once
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeANY)
+
+	answer := resolver.Lookup(query)
+
+	if len(answer.Answer) != 4 {
+		t.Error("Expected four answers, got ", len(answer.Answer))
+		t.Fatal()
+	}
+
+	// Invalid generic records
+	client.Set("TestLookupAnswerForGenericRecords/net/disco/baz/.RR", "BOGUS 1.2.3.4", 0)
+
+	records, err := resolver.LookupAnswersForType("baz.disco.net.", dns.TypeA)
+
+	if len(records) > 0 {
+		t.Error("Expected no answers, got ", len(records))
+		t.Fatal()
+	}
+
+	if _, ok := err.(*NodeConversionError); !ok {
+		t.Error("Expected NodeConversionError, got", err)
+		t.Fatal()
+	}
+}
+
+// writeTestGeoIP writes a MaxMind DB file with the given IP version and record
+// size holding the given records for each network, which mustn't overlap,
+// returning its path.
+func writeTestGeoIP(t *testing.T, ipVersion int, recordSize int, networks map[string]interface{}) string {
+	var data []byte
+
+	// Records are node numbers, or -1 for none and -(offset+2) for data
+	nodes := [][2]int{{-1, -1}}
+	for cidr, record := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		address := []byte(network.IP.To4())
+		ones, _ := network.Mask.Size()
+		if ipVersion == 6 {
+			address = append(make([]byte, 12), address...)
+			ones += 96
+		}
+
+		offset := len(data)
+		data = append(data, encodeTestGeoIP(record)...)
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(address[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -(offset + 2)
+			} else {
+				if nodes[node][bit] < 0 {
+					nodes = append(nodes, [2]int{-1, -1})
+					nodes[node][bit] = len(nodes) - 1
+				}
+				node = nodes[node][bit]
+			}
+		}
+	}
+
+	var buffer []byte
+	for _, node := range nodes {
+		for _, record := range node {
+			value := uint32(len(nodes))
+			if record >= 0 {
+				value = uint32(record)
+			} else if record < -1 {
+				value = uint32(len(nodes) + 16 - record - 2)
+			}
+
+			b := make([]byte, 4)
+			binary.BigEndian.PutUint32(b, value)
+			buffer = append(buffer, b[4-recordSize/8:]...)
+		}
+	}
+
+	buffer = append(buffer, make([]byte, 16)...)
+	buffer = append(buffer, data...)
+	buffer = append(buffer, mmdbMetadataMarker...)
+	buffer = append(buffer, encodeTestGeoIP(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "Test"})...)
+
+	file, err := ioutil.TempFile("", "discodns-geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Name()
+}
+
+// encodeTestGeoIP encodes a value for the data section of a MaxMind DB file,
+// supporting only what's needed for the tests.
+func encodeTestGeoIP(value interface{}) (b []byte) {
+	control := func(fieldType int, size int) []byte {
+		if fieldType > 7 {
+			return []byte{byte(size), byte(fieldType - 7)}
+		}
+		return []byte{byte(fieldType<<5 | size)}
+	}
+
+	switch value := value.(type) {
+	case string:
+		b = append(control(mmdbString, len(value)), value...)
+	case uint16:
+		b = append(control(mmdbUint16, 2), byte(value>>8), byte(value))
+	case uint32:
+		b = append(control(mmdbUint32, 4), byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+	case []interface{}:
+		b = control(mmdbArray, len(value))
+		for _, item := range value {
+			b = append(b, encodeTestGeoIP(item)...)
+		}
+	case map[string]interface{}:
+		b = control(mmdbMap, len(value))
+		for key, item := range value {
+			b = append(b, encodeTestGeoIP(key)...)
+			b = append(b, encodeTestGeoIP(item)...)
+		}
+	}
+
+	return
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"net"
+	"sort"
 	"strconv"
 	"time"
 
@@ -9,19 +11,43 @@ import (
 	"github.com/rcrowley/go-metrics"
 )
 
+// Policies for answering ANY queries received over UDP, to avoid them being
+// used for amplification attacks (see RFC 8482). Queries over TCP always get a
+// full answer.
+const (
+	AnyPolicyFull   = "full"   // Answer with every record for the name
+	AnyPolicyTCP    = "tcp"    // Respond truncated, forcing the client to use TCP
+	AnyPolicySingle = "single" // Answer with a single representative RRset
+	AnyPolicyHINFO  = "hinfo"  // Answer with a synthetic HINFO record
+)
+
 type Server struct {
-	addr          string
-	port          int
-	etcd          *etcd.Client
-	rTimeout      time.Duration
-	wTimeout      time.Duration
-	defaultTtl    uint32
-	queryFilterer *QueryFilterer
+	addr           string
+	port           int
+	etcd           *etcd.Client
+	rTimeout       time.Duration
+	wTimeout       time.Duration
+	defaultTtl     uint32
+	minTtl         uint32
+	maxTtl         uint32
+	aliasUpstream  string
+	genericRR      bool
+	skydnsPrefix   string
+	answerOrder    string
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	anyPolicy      string
+	queryFilterer  *QueryFilterer
+	views          []*View
+	geo            *GeoIP
+	settingsTtl    time.Duration
 }
 
 type Handler struct {
 	resolver      *Resolver
 	queryFilterer *QueryFilterer
+	anyPolicy     string
+	views         []*View // Checked in order, the first containing the client is used
 
 	// Metrics
 	requestCounter metrics.Counter
@@ -33,30 +59,30 @@ type Handler struct {
 func (h *Handler) Handle(response dns.ResponseWriter, req *dns.Msg) {
 	h.requestCounter.Inc(1)
 	h.responseTimer.Time(func() {
-		debugMsg("Handling incoming query for domain " + req.Question[0].Name)
-
-		// Lookup the dns record for the request
-		// This method will add any answers to the message
 		var msg *dns.Msg
-		if h.queryFilterer.ShouldAcceptQuery(req) != true {
-			debugMsg("Query not accepted")
+		if len(req.Question) != 1 {
+			// Nobody supports multiple questions in a single query, and a
+			// query without a question makes no sense
+			debugMsg("Query with ", len(req.Question), " questions, responding with FORMERR")
 
-			h.rejectCounter.Inc(1)
+			msg = new(dns.Msg)
+			msg.SetRcodeFormatError(req)
+		} else if req.Opcode != dns.OpcodeQuery {
+			debugMsg("Unsupported opcode ", req.Opcode, ", responding with NOTIMP")
 
 			msg = new(dns.Msg)
-			msg.SetReply(req)
-			msg.SetRcode(req, dns.RcodeNameError)
-			msg.Authoritative = true
-			msg.RecursionAvailable = false
+			msg.SetRcode(req, dns.RcodeNotImplemented)
+			msg.Opcode = req.Opcode
+		} else if subnet, err := clientSubnet(req); err != nil {
+			debugMsg("Invalid client subnet option, responding with FORMERR: ", err)
 
-			// Add a useful TXT record
-			header := dns.RR_Header{Name: req.Question[0].Name,
-				Class:  dns.ClassINET,
-				Rrtype: dns.TypeTXT}
-			msg.Ns = []dns.RR{&dns.TXT{header, []string{"Rejected query based on matched filters"}}}
+			msg = new(dns.Msg)
+			msg.SetRcodeFormatError(req)
 		} else {
-			h.acceptCounter.Inc(1)
-			msg = h.resolver.Lookup(req)
+			msg = h.handleQuery(req, clientAddress(response, subnet))
+			if subnet != nil {
+				h.setClientSubnetScope(req, msg, subnet)
+			}
 		}
 
 		if msg != nil {
@@ -70,6 +96,134 @@ func (h *Handler) Handle(response dns.ResponseWriter, req *dns.Msg) {
 	})
 }
 
+// handleQuery answers a standard query containing exactly one question from
+// the given client, returning the message to respond with.
+func (h *Handler) handleQuery(req *dns.Msg, client net.IP) (msg *dns.Msg) {
+	debugMsg("Handling incoming query for domain " + req.Question[0].Name)
+
+	// Lookup the dns record for the request
+	// This method will add any answers to the message
+	if h.queryFilterer.ShouldAcceptQuery(req) != true {
+		debugMsg("Query not accepted")
+
+		h.rejectCounter.Inc(1)
+
+		msg = new(dns.Msg)
+		msg.SetReply(req)
+		msg.SetRcode(req, dns.RcodeNameError)
+		msg.Authoritative = true
+		msg.RecursionAvailable = false
+
+		// Add a useful TXT record
+		header := dns.RR_Header{Name: req.Question[0].Name,
+			Class:  dns.ClassINET,
+			Rrtype: dns.TypeTXT}
+		msg.Ns = []dns.RR{&dns.TXT{header, []string{"Rejected query based on matched filters"}}}
+	} else {
+		h.acceptCounter.Inc(1)
+		msg = h.answerFromView(req, client)
+	}
+
+	return
+}
+
+// answer answers an accepted query using the given resolver.
+func (h *Handler) answer(resolver *Resolver, req *dns.Msg, client net.IP) *dns.Msg {
+	if req.Question[0].Qtype == dns.TypeANY {
+		return h.handleANY(resolver, req, client)
+	}
+
+	return resolver.LookupForClient(req, client)
+}
+
+// handleANY answers an ANY query according to the handler's ANY policy.
+func (h *Handler) handleANY(resolver *Resolver, req *dns.Msg, client net.IP) (msg *dns.Msg) {
+	q := req.Question[0]
+
+	switch h.anyPolicy {
+	case AnyPolicyTCP:
+		debugMsg("Truncating response to ANY query")
+
+		msg = new(dns.Msg)
+		msg.SetReply(req)
+		msg.Authoritative = true
+		msg.RecursionAvailable = false
+		msg.Truncated = true
+	case AnyPolicyHINFO:
+		// The lookup decides whether there's anything to answer with, as
+		// names that don't exist can still have answers from a wildcard
+		msg = resolver.LookupForClient(req, client)
+		if len(msg.Answer) > 0 {
+			debugMsg("Answering ANY query with synthetic HINFO")
+
+			header := dns.RR_Header{Name: q.Name,
+				Class:  dns.ClassINET,
+				Rrtype: dns.TypeHINFO,
+				Ttl:    resolver.defaultTtl}
+			msg.Answer = []dns.RR{&dns.HINFO{Hdr: header, Cpu: "RFC8482", Os: ""}}
+			msg.Extra = nil
+		}
+	case AnyPolicySingle:
+		msg = resolver.LookupForClient(req, client)
+		if len(msg.Answer) > 0 {
+			// Pick the RRset with the lowest type number, so the answer
+			// is consistent between queries
+			sort.Stable(byType(msg.Answer))
+
+			rrType := msg.Answer[0].Header().Rrtype
+			answers := []dns.RR{}
+			for _, rr := range msg.Answer {
+				if rr.Header().Rrtype == rrType {
+					answers = append(answers, rr)
+				}
+			}
+
+			msg.Answer = answers
+			msg.Extra = resolver.AdditionalRecords(answers)
+		}
+	default:
+		msg = resolver.LookupForClient(req, client)
+	}
+
+	return
+}
+
+// clientAddress returns the address of the client a query is on behalf of,
+// which is the address given by the query's EDNS client subnet option if there
+// is one (as when the query comes via a forwarder), otherwise the address the
+// query came from. A client subnet with a source prefix length of zero means
+// the client's address shouldn't be used, so the query's address is instead.
+func clientAddress(response dns.ResponseWriter, subnet *dns.EDNS0_SUBNET) net.IP {
+	if subnet != nil && subnet.SourceNetmask > 0 {
+		return subnet.Address
+	}
+
+	switch addr := response.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+
+	return nil
+}
+
+// byType sorts resource records by their type, keeping records of the same
+// type in their original order.
+type byType []dns.RR
+
+func (rrs byType) Len() int {
+	return len(rrs)
+}
+
+func (rrs byType) Less(i, j int) bool {
+	return rrs[i].Header().Rrtype < rrs[j].Header().Rrtype
+}
+
+func (rrs byType) Swap(i, j int) {
+	rrs[i], rrs[j] = rrs[j], rrs[i]
+}
+
 func (s *Server) Addr() string {
 	return s.addr + ":" + strconv.Itoa(s.port)
 }
@@ -94,27 +248,54 @@ func (s *Server) Run() {
 	udpRejectCounter := metrics.NewCounter()
 	metrics.Register("request.handler.udp.filter_rejects", udpRejectCounter)
 
-	resolver := Resolver{etcd: s.etcd, defaultTtl: s.defaultTtl}
+	resolver := Resolver{
+		etcd:           s.etcd,
+		defaultTtl:     s.defaultTtl,
+		minTtl:         s.minTtl,
+		maxTtl:         s.maxTtl,
+		aliasUpstream:  s.aliasUpstream,
+		genericRecords: s.genericRR,
+		skydnsPrefix:   s.skydnsPrefix,
+		answerOrder:    s.answerOrder,
+		geo:            s.geo}
+	if s.settingsTtl > 0 {
+		resolver.settingsCache = newSettingsCache(s.settingsTtl)
+	}
+	if s.healthInterval > 0 {
+		resolver.health = NewHealthChecker(s.etcd, "", s.healthInterval, s.healthTimeout)
+		go resolver.health.Run()
+	}
+
+	// Each view reads records from its own prefix, sharing everything else
+	for _, view := range s.views {
+		viewResolver := resolver
+		viewResolver.etcdPrefix = view.prefix
+		view.resolver = &viewResolver
+	}
+
 	tcpDNShandler := &Handler{
 		resolver:       &resolver,
+		anyPolicy:      AnyPolicyFull,
 		requestCounter: tcpRequestCounter,
 		acceptCounter:  tcpAcceptCounter,
 		rejectCounter:  tcpRejectCounter,
 		responseTimer:  tcpResponseTimer,
-		queryFilterer:  s.queryFilterer}
+		queryFilterer:  s.queryFilterer,
+		views:          s.views}
 	udpDNShandler := &Handler{
 		resolver:       &resolver,
+		anyPolicy:      s.anyPolicy,
 		requestCounter: udpRequestCounter,
 		acceptCounter:  udpAcceptCounter,
 		rejectCounter:  udpRejectCounter,
 		responseTimer:  udpResponseTimer,
-		queryFilterer:  s.queryFilterer}
-
-	udpHandler := dns.NewServeMux()
-	tcpHandler := dns.NewServeMux()
+		queryFilterer:  s.queryFilterer,
+		views:          s.views}
 
-	tcpHandler.HandleFunc(".", tcpDNShandler.Handle)
-	udpHandler.HandleFunc(".", udpDNShandler.Handle)
+	// The handlers are used directly rather than through a dns.ServeMux, as
+	// the mux answers queries without a question itself with SERVFAIL
+	udpHandler := dns.HandlerFunc(udpDNShandler.Handle)
+	tcpHandler := dns.HandlerFunc(tcpDNShandler.Handle)
 
 	tcpServer := &dns.Server{Addr: s.Addr(),
 		Net:          "tcp",
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestHandleNoQuestions(t *testing.T) {
+	handler := newTestHandler()
+	writer := &testResponseWriter{}
+
+	query := new(dns.Msg)
+	handler.Handle(writer, query)
+
+	if writer.msg == nil {
+		t.Error("Expected a response to be written")
+		t.Fatal()
+	}
+
+	if writer.msg.Rcode != dns.RcodeFormatError {
+		t.Error("Expected FORMERR response code, got", dns.RcodeToString[writer.msg.Rcode])
+		t.Fatal()
+	}
+}
+
+func TestHandleMultipleQuestions(t *testing.T) {
+	handler := newTestHandler()
+	writer := &testResponseWriter{}
+
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeA)
+	query.Question = append(query.Question, dns.Question{
+		Name:   "baz.disco.net.",
+		Qtype:  dns.TypeA,
+		Qclass: dns.ClassINET})
+
+	handler.Handle(writer, query)
+
+	if writer.msg == nil {
+		t.Error("Expected a response to be written")
+		t.Fatal()
+	}
+
+	if writer.msg.Rcode != dns.RcodeFormatError {
+		t.Error("Expected FORMERR response code, got", dns.RcodeToString[writer.msg.Rcode])
+		t.Fatal()
+	}
+
+	if writer.msg.Id != query.Id {
+		t.Error("Expected response ID to match query ID")
+		t.Fatal()
+	}
+}
+
+func TestHandleUnsupportedOpcode(t *testing.T) {
+	handler := newTestHandler()
+
+	for _, opcode := range []int{dns.OpcodeStatus, dns.OpcodeIQuery, 3} {
+		writer := &testResponseWriter{}
+
+		query := new(dns.Msg)
+		query.SetQuestion("bar.disco.net.", dns.TypeA)
+		query.Opcode = opcode
+
+		handler.Handle(writer, query)
+
+		if writer.msg == nil {
+			t.Error("Expected a response to be written")
+			t.Fatal()
+		}
+
+		if writer.msg.Rcode != dns.RcodeNotImplemented {
+			t.Error("Expected NOTIMP response code, got", dns.RcodeToString[writer.msg.Rcode])
+			t.Fatal()
+		}
+
+		if writer.msg.Opcode != opcode {
+			t.Error("Expected response opcode to match query opcode:", writer.msg.Opcode)
+			t.Fatal()
+		}
+	}
+}
+
+func TestHandleOutOfZone(t *testing.T) {
+	resolver.etcdPrefix = "TestHandleOutOfZone/"
+	client.Set("TestHandleOutOfZone/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	handler := newTestHandler()
+	writer := &testResponseWriter{}
+
+	query := new(dns.Msg)
+	query.SetQuestion("bar.example.com.", dns.TypeA)
+
+	handler.Handle(writer, query)
+
+	if writer.msg == nil {
+		t.Error("Expected a response to be written")
+		t.Fatal()
+	}
+
+	if writer.msg.Rcode != dns.RcodeRefused {
+		t.Error("Expected REFUSED response code, got", dns.RcodeToString[writer.msg.Rcode])
+		t.Fatal()
+	}
+
+	if writer.msg.Authoritative {
+		t.Error("Didn't expect an authoritative answer")
+		t.Fatal()
+	}
+
+	// Names within the zone should still be NXDOMAIN
+	writer = &testResponseWriter{}
+
+	query = new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeA)
+
+	handler.Handle(writer, query)
+
+	if writer.msg.Rcode != dns.RcodeNameError {
+		t.Error("Expected NXDOMAIN response code, got", dns.RcodeToString[writer.msg.Rcode])
+		t.Fatal()
+	}
+}
+
+func TestHandleANYPolicies(t *testing.T) {
+	resolver.etcdPrefix = "TestHandleANYPolicies/"
+	client.Set("TestHandleANYPolicies/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestHandleANYPolicies/net/disco/bar/.TXT", "foo", 0)
+	client.Set("TestHandleANYPolicies/net/disco/bar/.AAAA", "::1", 0)
+	client.Set("TestHandleANYPolicies/net/disco/bar/.A/0", "1.2.3.4", 0)
+	client.Set("TestHandleANYPolicies/net/disco/bar/.A/1", "2.3.4.5", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	handler := newTestHandler()
+
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeANY)
+
+	// Full
+	handler.anyPolicy = AnyPolicyFull
+	writer := &testResponseWriter{}
+	handler.Handle(writer, query)
+
+	if len(writer.msg.Answer) != 4 {
+		t.Error("Expected four answers, got ", len(writer.msg.Answer))
+		t.Fatal()
+	}
+
+	// TCP
+	handler.anyPolicy = AnyPolicyTCP
+	writer = &testResponseWriter{}
+	handler.Handle(writer, query)
+
+	if len(writer.msg.Answer) != 0 {
+		t.Error("Expected no answers, got ", len(writer.msg.Answer))
+		t.Fatal()
+	}
+	if !writer.msg.Truncated {
+		t.Error("Expected truncated response")
+		t.Fatal()
+	}
+
+	// Single
+	handler.anyPolicy = AnyPolicySingle
+	writer = &testResponseWriter{}
+	handler.Handle(writer, query)
+
+	if len(writer.msg.Answer) != 2 {
+		t.Error("Expected two answers, got ", len(writer.msg.Answer))
+		t.Fatal()
+	}
+	for _, rr := range writer.msg.Answer {
+		if rr.Header().Rrtype != dns.TypeA {
+			t.Error("Expected record with type A:", rr.Header().Rrtype)
+			t.Fatal()
+		}
+	}
+
+	// HINFO
+	handler.anyPolicy = AnyPolicyHINFO
+	writer = &testResponseWriter{}
+	handler.Handle(writer, query)
+
+	if len(writer.msg.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(writer.msg.Answer))
+		t.Fatal()
+	}
+
+	rr := writer.msg.Answer[0].(*dns.HINFO)
+	if rr.Header().Name != "bar.disco.net." {
+		t.Error("Expected record with name bar.disco.net.: ", rr.Header().Name)
+		t.Fatal()
+	}
+	if rr.Cpu != "RFC8482" {
+		t.Error("Expected HINFO CPU to be RFC8482: ", rr.Cpu)
+		t.Fatal()
+	}
+
+	// HINFO for a name that doesn't exist
+	query = new(dns.Msg)
+	query.SetQuestion("baz.disco.net.", dns.TypeANY)
+
+	writer = &testResponseWriter{}
+	handler.Handle(writer, query)
+
+	if writer.msg.Rcode != dns.RcodeNameError {
+		t.Error("Expected NXDOMAIN response code, got", dns.RcodeToString[writer.msg.Rcode])
+		t.Fatal()
+	}
+
+	// Names answered from a wildcard are subject to the policy too
+	client.Set("TestHandleANYPolicies/net/disco/*/.TXT", "foo", 0)
+	client.Set("TestHandleANYPolicies/net/disco/*/.AAAA", "::1", 0)
+	client.Set("TestHandleANYPolicies/net/disco/*/.A", "1.2.3.4", 0)
+
+	query = new(dns.Msg)
+	query.SetQuestion("random123.disco.net.", dns.TypeANY)
+
+	writer = &testResponseWriter{}
+	handler.Handle(writer, query)
+
+	if len(writer.msg.Answer) != 1 || writer.msg.Answer[0].Header().Rrtype != dns.TypeHINFO {
+		t.Error("Expected a single HINFO answer for a wildcard, got", writer.msg.Answer)
+		t.Fatal()
+	}
+
+	handler.anyPolicy = AnyPolicySingle
+	writer = &testResponseWriter{}
+	handler.Handle(writer, query)
+
+	if len(writer.msg.Answer) != 1 || writer.msg.Answer[0].Header().Rrtype != dns.TypeA {
+		t.Error("Expected a single A answer for a wildcard, got", writer.msg.Answer)
+		t.Fatal()
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	writer := &testResponseWriter{}
+
+	query := new(dns.Msg)
+	query.SetQuestion("bar.disco.net.", dns.TypeA)
+
+	subnet, err := clientSubnet(query)
+	if subnet != nil || err != nil {
+		t.Error("Didn't expect a client subnet:", subnet, err)
+		t.Fatal()
+	}
+	if client := clientAddress(writer, subnet); !client.Equal(net.ParseIP("127.0.0.1")) {
+		t.Error("Expected the remote address as the client:", client)
+		t.Fatal()
+	}
+
+	// The client subnet option takes precedence, masked to its prefix length
+	query.SetEdns0(4096, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 20,
+		Address:       net.ParseIP("10.1.255.0")})
+
+	subnet, err = clientSubnet(query)
+	if err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+	if client := clientAddress(writer, subnet); !client.Equal(net.ParseIP("10.1.240.0")) {
+		t.Error("Expected the client subnet address as the client:", client)
+		t.Fatal()
+	}
+
+	// Unless the client asked for its address not to be used
+	subnet.SourceNetmask = 0
+	if client := clientAddress(writer, subnet); !client.Equal(net.ParseIP("127.0.0.1")) {
+		t.Error("Expected the remote address as the client:", client)
+		t.Fatal()
+	}
+}
+
+func TestHandleClientSubnet(t *testing.T) {
+	resolver.etcdPrefix = "TestHandleClientSubnet/"
+	client.Set("TestHandleClientSubnet/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestHandleClientSubnet/net/disco/bar/.A/0", "1.1.1.1", 0)
+	client.Set("TestHandleClientSubnet/net/disco/bar/.A/1", "2.2.2.2", 0)
+	defer client.Delete(resolver.etcdPrefix, true)
+
+	handler := newTestHandler()
+
+	query := func(family uint16, netmask uint8, scope uint8, address string) *dns.Msg {
+		query := new(dns.Msg)
+		query.SetQuestion("bar.disco.net.", dns.TypeA)
+		query.SetEdns0(4096, false)
+		opt := query.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        family,
+			SourceNetmask: netmask,
+			SourceScope:   scope,
+			Address:       net.ParseIP(address)})
+
+		writer := &testResponseWriter{}
+		handler.Handle(writer, query)
+		return writer.msg
+	}
+
+	responseSubnet := func(msg *dns.Msg) *dns.EDNS0_SUBNET {
+		opt := msg.IsEdns0()
+		if opt == nil {
+			t.Error("Expected an OPT record in the response")
+			t.Fatal()
+		}
+
+		for _, option := range opt.Option {
+			if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+				return subnet
+			}
+		}
+
+		t.Error("Expected a client subnet option in the response")
+		t.Fatal()
+		return nil
+	}
+
+	// The answers don't depend on the client
+	msg := query(1, 24, 0, "10.1.2.3")
+	if len(msg.Answer) != 2 {
+		t.Error("Expected two answers, got ", len(msg.Answer))
+		t.Fatal()
+	}
+
+	subnet := responseSubnet(msg)
+	if subnet.SourceNetmask != 24 || subnet.SourceScope != 0 || !subnet.Address.Equal(net.ParseIP("10.1.2.0")) {
+		t.Error("Unexpected client subnet in response:", subnet)
+		t.Fatal()
+	}
+
+	// Answers picked by hashing the client depend on all of the subnet
+	client.Set("TestHandleClientSubnet/net/disco/bar/.order", "hash", 0)
+
+	subnet = responseSubnet(query(2, 56, 0, "2001:db8::"))
+	if subnet.SourceNetmask != 56 || subnet.SourceScope != 56 {
+		t.Error("Unexpected client subnet in response:", subnet)
+		t.Fatal()
+	}
+
+	// Invalid options are a format error
+	for _, msg := range []*dns.Msg{query(1, 33, 0, "10.1.2.3"), query(1, 24, 24, "10.1.2.3"), query(3, 24, 0, "10.1.2.3")} {
+		if msg.Rcode != dns.RcodeFormatError {
+			t.Error("Expected FORMERR response code, got", dns.RcodeToString[msg.Rcode])
+			t.Fatal()
+		}
+	}
+
+	// Prefixes that aren't whole bytes keep their last partial byte once the
+	// query has been through the wire format
+	client.Set("TestHandleClientSubnet/vpn/net/disco/bar/.A", "10.1.1.1", 0)
+
+	view, err := ParseView("TestHandleClientSubnet/vpn=10.1.16.0/20")
+	if err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+
+	viewResolver := *resolver
+	viewResolver.etcdPrefix = view.prefix
+	view.resolver = &viewResolver
+	handler.views = []*View{view}
+
+	req := new(dns.Msg)
+	req.SetQuestion("bar.disco.net.", dns.TypeA)
+	req.SetEdns0(4096, false)
+	req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 20,
+		Address:       net.ParseIP("10.1.31.0")})
+
+	packed, err := req.Pack()
+	if err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+	if err = req.Unpack(packed); err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+
+	writer := &testResponseWriter{}
+	handler.Handle(writer, req)
+	msg = writer.msg
+
+	if len(msg.Answer) != 1 {
+		t.Error("Expected one answer, got ", len(msg.Answer))
+		t.Fatal()
+	}
+	if rr := msg.Answer[0].(*dns.A); rr.A.String() != "10.1.1.1" {
+		t.Error("Expected the answer for the client subnet's view, got " + rr.A.String())
+		t.Fatal()
+	}
+
+	subnet = responseSubnet(msg)
+	if subnet.SourceNetmask != 20 || subnet.SourceScope != 20 || !subnet.Address.Equal(net.ParseIP("10.1.16.0")) {
+		t.Error("Unexpected client subnet in response:", subnet)
+		t.Fatal()
+	}
+}
+
+func TestHandleViews(t *testing.T) {
+	resolver.etcdPrefix = "TestHandleViews/default/"
+	client.Set("TestHandleViews/default/net/disco/.SOA", "ns1.disco.net.\tadmin.disco.net.\t3600\t600\t86400\t10", 0)
+	client.Set("TestHandleViews/default/net/disco/bar/.A", "1.1.1.1", 0)
+	client.Set("TestHandleViews/default/net/disco/baz/.A", "2.2.2.2", 0)
+	client.Set("TestHandleViews/vpn/net/disco/bar/.A", "10.1.1.1", 0)
+	defer client.Delete("TestHandleViews/", true)
+
+	view, err := ParseView("TestHandleViews/vpn=10.0.0.0/8,127.0.0.0/8")
+	if err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+
+	viewResolver := *resolver
+	viewResolver.etcdPrefix = view.prefix
+	view.resolver = &viewResolver
+
+	handler := newTestHandler()
+	handler.views = []*View{view}
+
+	expect := func(name string, address string) {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+
+		writer := &testResponseWriter{}
+		handler.Handle(writer, query)
+
+		if len(writer.msg.Answer) != 1 {
+			t.Error("Expected one answer, got ", len(writer.msg.Answer))
+			t.Fatal()
+		}
+		if rr := writer.msg.Answer[0].(*dns.A); rr.A.String() != address {
+			t.Error("Expected " + address + ", got " + rr.A.String())
+			t.Fatal()
+		}
+	}
+
+	// The test client is in the view, and names it has no records for fall
+	// through to the default records
+	expect("bar.disco.net.", "10.1.1.1")
+	expect("baz.disco.net.", "2.2.2.2")
+
+	if view.queryCounter.Count() != 2 || view.answerCounter.Count() != 1 || view.fallthroughCounter.Count() != 1 {
+		t.Error("Unexpected view metrics:", view.queryCounter.Count(), view.answerCounter.Count(), view.fallthroughCounter.Count())
+		t.Fatal()
+	}
+
+	// Clients outside the view get the default records
+	view.networks = view.networks[:1]
+	expect("bar.disco.net.", "1.1.1.1")
+}
+
+func TestParseView(t *testing.T) {
+	view, err := ParseView("/views/vpn/=10.8.0.0/16, fd00::/8")
+	if err != nil {
+		t.Error("Unexpected error: ", err)
+		t.Fatal()
+	}
+
+	if view.prefix != "/views/vpn" || view.name != "views_vpn" {
+		t.Error("Unexpected view prefix or name:", view.prefix, view.name)
+		t.Fatal()
+	}
+	if !view.Contains(net.ParseIP("10.8.1.2")) || !view.Contains(net.ParseIP("fd00::1")) {
+		t.Error("Expected the view to contain its networks")
+		t.Fatal()
+	}
+	if view.Contains(net.ParseIP("10.9.1.2")) || view.Contains(nil) {
+		t.Error("Didn't expect the view to contain other clients")
+		t.Fatal()
+	}
+
+	for _, invalid := range []string{"/views/vpn", "=10.0.0.0/8", "/views/vpn=10.0.0.0"} {
+		if _, err := ParseView(invalid); err == nil {
+			t.Error("Expected an error for view " + invalid)
+			t.Fatal()
+		}
+	}
+}
+
+// newTestHandler returns a Handler using the shared test resolver, with no
+// query filters.
+func newTestHandler() *Handler {
+	return &Handler{
+		resolver:       resolver,
+		queryFilterer:  &QueryFilterer{},
+		anyPolicy:      AnyPolicyFull,
+		requestCounter: metrics.NewCounter(),
+		acceptCounter:  metrics.NewCounter(),
+		rejectCounter:  metrics.NewCounter(),
+		responseTimer:  metrics.NewTimer()}
+}
+
+// testResponseWriter is a dns.ResponseWriter that holds on to the last message
+// written to it.
+type testResponseWriter struct {
+	msg *dns.Msg
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+}
+
+func (w *testResponseWriter) WriteMsg(msg *dns.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func (w *testResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *testResponseWriter) Close() error        { return nil }
+func (w *testResponseWriter) TsigStatus() error   { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool) {}
+func (w *testResponseWriter) Hijack()             {}
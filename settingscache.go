@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// maxCachedSettings limits how many levels of the tree have their settings
+// cached, as queries for random names would otherwise grow the cache forever
+const maxCachedSettings = 10000
+
+// settingsCache holds the settings stored at each level of the tree for a
+// short time, so they aren't fetched from etcd for every query.
+type settingsCache struct {
+	ttl time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*cachedSettings
+}
+
+type cachedSettings struct {
+	values  map[string]string // Nil if there's nothing stored at the level
+	expires time.Time
+}
+
+func newSettingsCache(ttl time.Duration) *settingsCache {
+	return &settingsCache{ttl: ttl, entries: make(map[string]*cachedSettings)}
+}
+
+// Get returns the settings cached for the given key, if they haven't expired.
+func (c *settingsCache) Get(key string) (values map[string]string, ok bool) {
+	hit_counter := metrics.GetOrRegisterCounter("resolver.settings.cache_hits", metrics.DefaultRegistry)
+	miss_counter := metrics.GetOrRegisterCounter("resolver.settings.cache_misses", metrics.DefaultRegistry)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		miss_counter.Inc(1)
+		return nil, false
+	}
+
+	hit_counter.Inc(1)
+	return entry.values, true
+}
+
+// Set caches the settings for the given key.
+func (c *settingsCache) Set(key string, values map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCachedSettings {
+		for cached, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, cached)
+			}
+		}
+
+		if len(c.entries) >= maxCachedSettings {
+			c.entries = make(map[string]*cachedSettings)
+		}
+	}
+
+	c.entries[key] = &cachedSettings{values: values, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/miekg/dns"
+	"github.com/rcrowley/go-metrics"
+)
+
+// skydnsService is a single service as published by SkyDNS (and the CoreDNS
+// etcd plugin), stored as JSON beneath a key made up of the reversed name.
+type skydnsService struct {
+	Host     string `json:"host"`
+	Port     uint16 `json:"port"`
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Text     string `json:"text"`
+	Ttl      uint32 `json:"ttl"`
+}
+
+// skydnsTypes are the record types that can be synthesized from services
+var skydnsTypes = map[uint16]bool{
+	dns.TypeA:    true,
+	dns.TypeAAAA: true,
+	dns.TypeSRV:  true,
+	dns.TypeTXT:  true}
+
+// LookupSkyDNS synthesizes records of the given type from the services stored
+// in the SkyDNS layout at (and beneath) the given name. For example, the
+// services in /skydns/local/cluster/svc/a and /skydns/local/cluster/svc/b
+// both answer queries for svc.cluster.local.
+func (r *Resolver) LookupSkyDNS(name string, rrType uint16) (answers []dns.RR, err error) {
+	if !skydnsTypes[rrType] {
+		return
+	}
+
+	counter := metrics.GetOrRegisterCounter("resolver.skydns.query_count", metrics.DefaultRegistry)
+	error_counter := metrics.GetOrRegisterCounter("resolver.skydns.query_error_count", metrics.DefaultRegistry)
+
+	name = strings.ToLower(name)
+	key := r.skydnsPrefix + nameToKey(name, "")
+
+	counter.Inc(1)
+	debugMsg("Querying etcd for SkyDNS services at " + key)
+
+	response, err := r.etcd.Get(key, true, true)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok {
+			if e.ErrorCode == 100 {
+				return answers, nil
+			}
+		}
+
+		error_counter.Inc(1)
+		return
+	}
+
+	var settings *RecordSettings
+	var findServices func(node *etcd.Node)
+	findServices = func(node *etcd.Node) {
+		if node.Dir {
+			for _, child := range node.Nodes {
+				findServices(child)
+			}
+			return
+		}
+
+		var service skydnsService
+		if err := json.Unmarshal([]byte(node.Value), &service); err != nil {
+			debugMsg("Unable to decode SkyDNS service "+node.Key+": ", err)
+			return
+		}
+
+		if settings == nil {
+			found := r.RecordSettings(name, typeToString(rrType))
+			settings = &found
+		}
+
+		if answer := r.skydnsRecord(name, rrType, node.Key, &service, settings); answer != nil {
+			answer.Header().Ttl = expiringTtl(answer.Header().Ttl, node)
+			answers = append(answers, answer)
+		}
+	}
+
+	findServices(response.Node)
+
+	return
+}
+
+// skydnsRecord returns a record of the given type for a single service, or nil
+// if the service can't be represented by a record of that type.
+func (r *Resolver) skydnsRecord(name string, rrType uint16, key string, service *skydnsService, settings *RecordSettings) dns.RR {
+	ttl := service.Ttl
+	if ttl == 0 {
+		ttl = settings.DefaultTtl
+	}
+
+	header := dns.RR_Header{Name: name, Class: dns.ClassINET, Rrtype: rrType, Ttl: settings.Clamp(ttl, key)}
+
+	ip := net.ParseIP(service.Host)
+
+	switch rrType {
+	case dns.TypeA:
+		if ip != nil && ip.To4() != nil {
+			return &dns.A{Hdr: header, A: ip.To4()}
+		}
+	case dns.TypeAAAA:
+		if ip != nil && ip.To4() == nil {
+			return &dns.AAAA{Hdr: header, AAAA: ip}
+		}
+	case dns.TypeSRV:
+		if len(service.Host) == 0 {
+			return nil
+		}
+
+		// Services with an address are the target of the SRV record
+		// themselves, by the name of their own key
+		target := dns.Fqdn(service.Host)
+		if ip != nil {
+			prefix := strings.TrimPrefix(r.skydnsPrefix, "/")
+			target = skydnsKeyToName(strings.TrimPrefix(strings.TrimPrefix(key, "/"), prefix))
+		}
+
+		priority := service.Priority
+		if priority == 0 {
+			priority = 10
+		}
+
+		return &dns.SRV{
+			Hdr:      header,
+			Priority: priority,
+			Weight:   service.Weight,
+			Port:     service.Port,
+			Target:   target}
+	case dns.TypeTXT:
+		if len(service.Text) > 0 {
+			return &dns.TXT{Hdr: header, Txt: splitTxtString(service.Text)}
+		}
+	}
+
+	return nil
+}
+
+// skydnsKeyToName is the reverse of nameToKey (/local/cluster/svc ->
+// svc.cluster.local.)
+func skydnsKeyToName(key string) string {
+	segments := strings.Split(strings.Trim(key, "/"), "/")
+	for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+		segments[i], segments[j] = segments[j], segments[i]
+	}
+
+	return dns.Fqdn(strings.Join(segments, "."))
+}
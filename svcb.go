@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/miekg/dns"
+)
+
+// The version of the DNS library we use predates SVCB and HTTPS records, so
+// we define the types here and build their wire format ourselves.
+const (
+	TypeSVCB  uint16 = 64
+	TypeHTTPS uint16 = 65
+)
+
+// SvcParam keys we know how to encode, see RFC 9460 section 14.3.2
+var svcParamKeys = map[string]uint16{
+	"alpn":     1,
+	"port":     3,
+	"ipv4hint": 4,
+	"ech":      5,
+	"ipv6hint": 6,
+}
+
+func init() {
+	// Register the type names so records can be stored under .SVCB and
+	// .HTTPS keys like any other type
+	dns.TypeToString[TypeSVCB] = "SVCB"
+	dns.TypeToString[TypeHTTPS] = "HTTPS"
+	dns.StringToType["SVCB"] = TypeSVCB
+	dns.StringToType["HTTPS"] = TypeHTTPS
+}
+
+// convertSVCB turns an etcd node into an SVCB or HTTPS record (depending on
+// the type in the given header). The value consists of the following
+// tab-delimited fields;
+//   - Priority (0 for alias mode)
+//   - Target name
+//   - SvcParams (optional), space separated key=value pairs such as
+//     "alpn=h2,h3 port=8443 ipv4hint=10.0.0.1"
+func convertSVCB(node *etcd.Node, header dns.RR_Header) (rr dns.RR, err error) {
+	rrType := header.Rrtype
+	parts := strings.SplitN(node.Value, "\t", 3)
+
+	if len(parts) < 2 {
+		err = &NodeConversionError{
+			Node:          node,
+			Message:       fmt.Sprintf("Value %s isn't valid for %s", node.Value, dns.TypeToString[rrType]),
+			AttemptedType: rrType}
+		return
+	}
+
+	priority, e := strconv.ParseUint(parts[0], 10, 16)
+	if e != nil {
+		err = &NodeConversionError{
+			Node:          node,
+			Message:       fmt.Sprintf("Priority '%s' isn't a 16bit unsigned int", parts[0]),
+			AttemptedType: rrType}
+		return
+	}
+
+	target := parts[1]
+	labels, ok := dns.IsDomainName(target)
+	if !ok || (labels == 0 && target != ".") {
+		err = &NodeConversionError{
+			Node:          node,
+			Message:       fmt.Sprintf("Target '%s' isn't a valid domain name", target),
+			AttemptedType: rrType}
+		return
+	}
+
+	rdata := make([]byte, 2+256)
+	rdata[0] = uint8(priority >> 8)
+	rdata[1] = uint8(priority)
+
+	off, e := dns.PackDomainName(dns.Fqdn(target), rdata, 2, nil, false)
+	if e != nil {
+		err = &NodeConversionError{
+			Node:          node,
+			Message:       fmt.Sprintf("Target '%s' isn't a valid domain name", target),
+			AttemptedType: rrType}
+		return
+	}
+	rdata = rdata[:off]
+
+	if len(parts) == 3 && len(strings.TrimSpace(parts[2])) > 0 {
+		if priority == 0 {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       "SvcParams can't be given in alias mode (priority 0)",
+				AttemptedType: rrType}
+			return
+		}
+
+		params, e := packSvcParams(parts[2])
+		if e != nil {
+			err = &NodeConversionError{
+				Node:          node,
+				Message:       e.Error(),
+				AttemptedType: rrType}
+			return
+		}
+		rdata = append(rdata, params...)
+	}
+
+	rr = rawRR(header, rdata)
+	return
+}
+
+// packSvcParams converts a space separated list of key=value SvcParams into
+// their wire format, ordered by key as required.
+func packSvcParams(value string) (packed []byte, err error) {
+	params := make(map[uint16][]byte)
+
+	for _, param := range strings.Fields(value) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || len(kv[1]) == 0 {
+			return nil, fmt.Errorf("SvcParam '%s' must be in the form key=value", param)
+		}
+
+		key, ok := svcParamKeys[strings.ToLower(kv[0])]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported SvcParam key '%s'", kv[0])
+		}
+
+		if _, ok := params[key]; ok {
+			return nil, fmt.Errorf("Duplicate SvcParam key '%s'", kv[0])
+		}
+
+		var data []byte
+		switch key {
+		case 1: // alpn
+			for _, id := range strings.Split(kv[1], ",") {
+				if len(id) == 0 || len(id) > 255 {
+					return nil, fmt.Errorf("Invalid alpn identifier '%s'", id)
+				}
+				data = append(data, uint8(len(id)))
+				data = append(data, id...)
+			}
+		case 3: // port
+			port, err := strconv.ParseUint(kv[1], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("Port '%s' isn't a 16bit unsigned int", kv[1])
+			}
+			data = []byte{uint8(port >> 8), uint8(port)}
+		case 4: // ipv4hint
+			for _, addr := range strings.Split(kv[1], ",") {
+				ip := net.ParseIP(addr)
+				if ip == nil || ip.To4() == nil {
+					return nil, fmt.Errorf("Value %s isn't an IPv4 address", addr)
+				}
+				data = append(data, ip.To4()...)
+			}
+		case 5: // ech
+			config, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("ECH config '%s' isn't valid base64", kv[1])
+			}
+			data = config
+		case 6: // ipv6hint
+			for _, addr := range strings.Split(kv[1], ",") {
+				ip := net.ParseIP(addr)
+				if ip == nil || ip.To4() != nil {
+					return nil, fmt.Errorf("Value %s isn't an IPv6 address", addr)
+				}
+				data = append(data, ip.To16()...)
+			}
+		}
+
+		if len(data) > 65535 {
+			return nil, fmt.Errorf("SvcParam '%s' is too long", kv[0])
+		}
+
+		params[key] = data
+	}
+
+	keys := make([]int, 0, len(params))
+	for key := range params {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+
+	for _, key := range keys {
+		data := params[uint16(key)]
+		packed = append(packed, uint8(key>>8), uint8(key), uint8(len(data)>>8), uint8(len(data)))
+		packed = append(packed, data...)
+	}
+
+	return
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rcrowley/go-metrics"
+)
+
+// View answers queries from clients within a set of networks using records
+// stored beneath an alternate etcd prefix, for split-horizon DNS. Queries the
+// view has no answers for fall through to the default records.
+type View struct {
+	name     string
+	prefix   string
+	networks []*net.IPNet
+	resolver *Resolver
+
+	// Metrics
+	queryCounter       metrics.Counter
+	answerCounter      metrics.Counter
+	fallthroughCounter metrics.Counter
+}
+
+// ParseView parses a view given as prefix=cidr[,cidr...], for example
+// /views/vpn=10.8.0.0/16,fd00::/8
+func ParseView(view string) (*View, error) {
+	components := strings.SplitN(view, "=", 2)
+	if len(components) != 2 || len(strings.Trim(components[0], "/")) == 0 {
+		return nil, fmt.Errorf("Expected a view of the form prefix=cidr[,cidr...], got '%s'", view)
+	}
+
+	prefix := "/" + strings.Trim(components[0], "/")
+	name := metricNameInvalid.ReplaceAllString(strings.Trim(prefix, "/"), "_")
+
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(components[1], ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return &View{
+		name:               name,
+		prefix:             prefix,
+		networks:           networks,
+		queryCounter:       metrics.GetOrRegisterCounter("view."+name+".queries", metrics.DefaultRegistry),
+		answerCounter:      metrics.GetOrRegisterCounter("view."+name+".answers", metrics.DefaultRegistry),
+		fallthroughCounter: metrics.GetOrRegisterCounter("view."+name+".fallthrough", metrics.DefaultRegistry)}, nil
+}
+
+// Contains returns true if the given client is within one of the view's
+// networks
+func (v *View) Contains(client net.IP) bool {
+	if client == nil {
+		return false
+	}
+
+	for _, network := range v.networks {
+		if network.Contains(client) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// viewFor returns the first view containing the given client, or nil if
+// there isn't one.
+func (h *Handler) viewFor(client net.IP) *View {
+	for _, view := range h.views {
+		if view.Contains(client) {
+			return view
+		}
+	}
+
+	return nil
+}
+
+// answerFromView answers a query from the view for the given client, if it
+// has one, falling through to the default records when the view has no
+// answers.
+func (h *Handler) answerFromView(req *dns.Msg, client net.IP) (msg *dns.Msg) {
+	if view := h.viewFor(client); view != nil {
+		debugMsg("Answering query from view " + view.name)
+		view.queryCounter.Inc(1)
+
+		msg = h.answer(view.resolver, req, client)
+		if len(msg.Answer) > 0 {
+			view.answerCounter.Inc(1)
+			return
+		}
+
+		debugMsg("No answers in view " + view.name + ", falling through")
+		view.fallthroughCounter.Inc(1)
+	}
+
+	return h.answer(h.resolver, req, client)
+}